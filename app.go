@@ -5,9 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/erwaen/Chirpy/auth"
+	"github.com/erwaen/Chirpy/blob"
+	"github.com/erwaen/Chirpy/moderation"
+	"github.com/erwaen/Chirpy/pubsub"
 	"github.com/erwaen/Chirpy/tursodb"
 	"github.com/erwaen/Chirpy/types"
 
@@ -18,10 +24,18 @@ import (
 
 type apiConfig struct {
 	fileserverHits int
-	db             *database.DB
-	jwtSecret      string
+	db             database.Store
+	keys           *auth.Keyring
 	polkaKey       string
 	tursoDB        *tursodb.TursoDB
+	moderator      *moderation.Moderator
+	trendingWindow time.Duration
+	blobs          blob.Store
+	maxUploadBytes int64
+	hub            *pubsub.Hub
+	publicURL      string
+	trustedProxies []*net.IPNet
+	wsOrigins      []string
 }
 
 func main() {
@@ -34,27 +48,15 @@ func main() {
 
 	godotenv.Load()
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtSecret == "" && jwtKeysDir == "" {
+		log.Fatal("JWT_SECRET or JWT_KEYS_DIR environment variable is not set")
 	}
 	polkaKey := os.Getenv("POLKA_KEY")
-	if jwtSecret == "" {
+	if polkaKey == "" {
 		log.Fatal("polka key environment variable is not set")
 	}
 
-	db, err := database.NewDB("./database.json")
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
-	if dbg != nil && *dbg {
-		err := db.ResetDB()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
 	tursoUrl := os.Getenv("TURSO_DATABASE_URL")
 	if tursoUrl == "" {
 		log.Fatal("TURSO_DATABASE_URL key environment variable is not set")
@@ -71,35 +73,168 @@ func main() {
 	defer tursoDB.Close()
 	tursoDBWrapper := tursodb.NewTursoDB(tursoDB)
 
+	db, err := newStore(os.Getenv("DB_BACKEND"), tursoDB)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	keys, err := newKeyring(jwtKeysDir, jwtSecret)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	moderator, err := newModerator(os.Getenv("MODERATION_RULES"))
+	if err != nil {
+		log.Fatalf("Failed to load moderation rules: %v", err)
+	}
+
+	trendingWindow, err := parseTrendingWindow(os.Getenv("TRENDING_WINDOW"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "uploads"
+	}
+	blobs, err := blob.NewLocalStore(mediaDir)
+	if err != nil {
+		log.Fatalf("Failed to open media directory: %v", err)
+	}
+	maxUploadBytes, err := parseMaxUploadBytes(os.Getenv("MEDIA_MAX_BYTES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schedulerInterval, err := parseSchedulerInterval(os.Getenv("SCHEDULER_INTERVAL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stockPollInterval, err := parseStockPollInterval(os.Getenv("STOCK_POLL_INTERVAL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retention, err := parseRetentionPeriod(os.Getenv("DELETED_CHIRP_RETENTION"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	publicURL, err := parsePublicURL(os.Getenv("PUBLIC_URL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wsOrigins, err := parseWSOrigins(os.Getenv("WS_ORIGINS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbg := flag.Bool("debug", false, "Enable debug mode")
+	admin := flag.String("admin", "", "Give the user with this email the admin role")
+	flag.Parse()
+	if dbg != nil && *dbg {
+		err := db.ResetDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *admin != "" {
+		if err := makeAdmin(db, *admin); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	hub := pubsub.NewHub(streamHistory, streamBuffer)
 	apiCfg := apiConfig{
 		fileserverHits: 0,
-		db:             db,
-		jwtSecret:      jwtSecret,
+		db:             storeEvents{Store: db, hub: hub},
+		keys:           keys,
 		polkaKey:       polkaKey,
 		tursoDB:        tursoDBWrapper,
+		moderator:      moderator,
+		trendingWindow: trendingWindow,
+		blobs:          blobs,
+		maxUploadBytes: maxUploadBytes,
+		hub:            hub,
+		publicURL:      publicURL,
+		trustedProxies: trustedProxies,
+		wsOrigins:      wsOrigins,
 	}
+	go apiCfg.runScheduler(schedulerInterval)
+	go apiCfg.runStockPoller(stockPollInterval)
+	go apiCfg.runPurger(retention)
+
 	mux := http.NewServeMux()
 	fhandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir("."))))
 	mux.Handle("/app/*", fhandler)
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
+	mux.HandleFunc("GET /api/reset", apiCfg.requireScope(auth.ScopeReset)(apiCfg.handlerReset))
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerNewChirp)
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerReadChirps)
-	mux.HandleFunc("GET /api/chirps/{id}", apiCfg.handlerReadChirps)
-	mux.HandleFunc("DELETE /api/chirps/{id}", apiCfg.handlerDeleteChirp)
+	mux.HandleFunc("POST /api/chirps", apiCfg.requireAuth(apiCfg.handlerNewChirp))
+	mux.HandleFunc("GET /api/chirps", apiCfg.optionalAuth(apiCfg.handlerReadChirps))
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.optionalAuth(apiCfg.handlerSearchChirps))
+	mux.HandleFunc("GET /api/chirps/stream", apiCfg.handlerChirpStream)
+	mux.HandleFunc("GET /api/chirps/{id}", apiCfg.optionalAuth(apiCfg.handlerReadChirps))
+	mux.HandleFunc("PUT /api/chirps/{id}", apiCfg.requireAuth(apiCfg.handlerUpdateChirp))
+	mux.HandleFunc("DELETE /api/chirps/{id}", apiCfg.requireAuth(apiCfg.handlerDeleteChirp))
+	mux.HandleFunc("GET /api/chirps/{id}/history", apiCfg.optionalAuth(apiCfg.handlerChirpHistory))
+	mux.HandleFunc("GET /api/chirps/{id}/replies", apiCfg.optionalAuth(apiCfg.handlerChirpReplies))
+	mux.HandleFunc("POST /api/chirps/{id}/attachments", apiCfg.requireAuth(apiCfg.handlerUploadAttachment))
+	mux.HandleFunc("POST /api/chirps/{id}/publish", apiCfg.requireAuth(apiCfg.handlerPublishChirp))
+	mux.HandleFunc("POST /api/chirps/{id}/like", apiCfg.requireAuth(apiCfg.handlerReaction(types.ReactionLike, false)))
+	mux.HandleFunc("DELETE /api/chirps/{id}/like", apiCfg.requireAuth(apiCfg.handlerReaction(types.ReactionLike, true)))
+	mux.HandleFunc("POST /api/chirps/{id}/repost", apiCfg.requireAuth(apiCfg.handlerReaction(types.ReactionRepost, false)))
+	mux.HandleFunc("DELETE /api/chirps/{id}/repost", apiCfg.requireAuth(apiCfg.handlerReaction(types.ReactionRepost, true)))
+	mux.HandleFunc("POST /api/chirps/{id}/bookmark", apiCfg.requireAuth(apiCfg.handlerBookmark(false)))
+	mux.HandleFunc("POST /api/chirps/{id}/report", apiCfg.requireAuth(apiCfg.handlerReportChirp))
+	mux.HandleFunc("DELETE /api/chirps/{id}/bookmark", apiCfg.requireAuth(apiCfg.handlerBookmark(true)))
+
+	mux.HandleFunc("GET /media/{key}", apiCfg.handlerMedia)
+	mux.HandleFunc("GET /api/ws", wsAccessToken(apiCfg.requireAuth(apiCfg.handlerWebSocket)))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWBUpgrade)
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerNewUser)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	mux.HandleFunc("PUT /api/users", apiCfg.requireAuth(apiCfg.handlerUpdateUser))
+	mux.HandleFunc("POST /api/users/{id}/follow", apiCfg.requireAuth(apiCfg.handlerFollow(false)))
+	mux.HandleFunc("DELETE /api/users/{id}/follow", apiCfg.requireAuth(apiCfg.handlerFollow(true)))
+	mux.HandleFunc("GET /api/users/{id}/followers", apiCfg.handlerFollowers)
+	mux.HandleFunc("GET /api/users/{id}/following", apiCfg.handlerFollowing)
+	mux.HandleFunc("GET /api/users/{id}/feed.atom", apiCfg.handlerFeed(true))
+	mux.HandleFunc("GET /api/users/{id}/feed.rss", apiCfg.handlerFeed(false))
+	mux.HandleFunc("GET /api/users/me/notifications", apiCfg.requireAuth(apiCfg.handlerNotifications))
+	mux.HandleFunc("GET /api/users/me/drafts", apiCfg.requireAuth(apiCfg.handlerDrafts))
+	mux.HandleFunc("GET /api/users/me/bookmarks", apiCfg.requireAuth(apiCfg.handlerBookmarks))
+	mux.HandleFunc("GET /api/users/me/sessions", apiCfg.requireAuth(apiCfg.handlerSessions))
+	mux.HandleFunc("DELETE /api/users/me/sessions", apiCfg.requireAuth(apiCfg.handlerRevokeAllSessions))
+	mux.HandleFunc("DELETE /api/users/me/sessions/{id}", apiCfg.requireAuth(apiCfg.handlerRevokeSession))
+	mux.HandleFunc("GET /api/timeline", apiCfg.requireAuth(apiCfg.handlerTimeline))
+	mux.HandleFunc("GET /api/hashtags/trending", apiCfg.handlerTrendingHashtags)
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	moderate := apiCfg.requireScope(auth.ScopeModerate)
+	rules := apiCfg.requireScope(auth.ScopeModerationRules)
+	mux.HandleFunc("GET /admin/metrics", apiCfg.requireScope(auth.ScopeMetrics)(apiCfg.handlerMetrics))
+	mux.HandleFunc("GET /admin/moderation/rules", rules(apiCfg.handlerModerationRules))
+	mux.HandleFunc("POST /admin/moderation/reload", rules(apiCfg.handlerModerationReload))
+	mux.HandleFunc("GET /admin/moderation/flagged", moderate(apiCfg.handlerFlaggedChirps))
+	mux.HandleFunc("GET /admin/reports", moderate(apiCfg.handlerReports))
+	mux.HandleFunc("POST /admin/reports/{id}/resolve", moderate(apiCfg.handlerResolveReport))
+	mux.HandleFunc("GET /admin/chirps/deleted", moderate(apiCfg.handlerDeletedChirps))
+	mux.HandleFunc("POST /admin/chirps/{id}/restore", moderate(apiCfg.handlerRestoreChirp))
+	mux.HandleFunc("PUT /admin/users/{id}/role", apiCfg.requireScope(auth.ScopeRoles)(apiCfg.handlerSetUserRole))
 
 	mux.HandleFunc("GET /api/tursousers", apiCfg.handlerTursoUsers)
 	mux.HandleFunc("GET /api/tursoitems", apiCfg.handlerTursoItems)
@@ -114,6 +249,20 @@ func main() {
 
 }
 
+// newStore picks the chirps/users backend from DB_BACKEND: "json" (the
+// default) keeps everything in ./database.json, "sql" uses the Turso
+// connection so the data survives machine restarts.
+func newStore(backend string, tursoDB *sql.DB) (database.Store, error) {
+	switch backend {
+	case "", "json":
+		return database.NewDB("./database.json")
+	case "sql":
+		return database.NewSQLDB(tursoDB)
+	default:
+		return nil, fmt.Errorf("unknown DB_BACKEND %q", backend)
+	}
+}
+
 type TursoUser struct {
 	ID   int
 	Name string
@@ -6,15 +6,48 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/erwaen/Chirpy/types"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("not auth header included in request")
 
+// ErrTokenRevoked is returned for access tokens issued before their
+// user logged out everywhere
+var ErrTokenRevoked = errors.New("token was revoked")
+
+// TokenVersionFunc returns the current token version of a user. Access
+// tokens carrying another version are rejected.
+type TokenVersionFunc func(userID int) (int, error)
+
+// Claims are the claims of the access tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	// TokenVersion is the token version of the user when the token was
+	// issued
+	TokenVersion int `json:"ver,omitempty"`
+	// Role is the role of the user when the token was issued
+	Role string `json:"role,omitempty"`
+	// Scope is the space separated list of the scopes of the token
+	Scope string `json:"scope,omitempty"`
+}
+
+// UserID is the ID of the user the token was issued to
+func (c *Claims) UserID() (int, error) {
+	return strconv.Atoi(c.Subject)
+}
+
+// HasScope reports whether the token carries scope
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -27,16 +60,20 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   fmt.Sprintf("%d", userID),
+// MakeJWT issues an access token for user, with the scopes of their
+// role, signed with the signing key of keys
+func MakeJWT(user types.User, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", user.Id),
+		},
+		TokenVersion: user.TokenVersion,
+		Role:         user.Role,
+		Scope:        strings.Join(ScopesForRole(user.Role), " "),
 	})
-	return token.SignedString(signingKey)
 }
 func MakeRefreshT() (string, error) {
 	b := make([]byte, 32)
@@ -48,31 +85,46 @@ func MakeRefreshT() (string, error) {
 	return refrestToken, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+// ValidateJWT checks an access token against the key of keys named by
+// its kid and returns its subject, the user ID. tokenVersion is asked
+// for the current token version of the user.
+func ValidateJWT(tokenString string, keys *Keyring, tokenVersion TokenVersionFunc) (string, error) {
+	claims, err := ParseJWT(tokenString, keys, tokenVersion)
 	if err != nil {
 		return "", err
 	}
+	return claims.Subject, nil
+}
 
-	userIDString, err := token.Claims.GetSubject()
+// ParseJWT is ValidateJWT returning all the claims of the token
+func ParseJWT(tokenString string, keys *Keyring, tokenVersion TokenVersionFunc) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.verifyKey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	issuer, err := claims.GetIssuer()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if issuer != string("chirpy") {
-		return "", errors.New("invalid issuer")
+		return nil, errors.New("invalid issuer")
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, err
+	}
+	version, err := tokenVersion(userID)
+	if err != nil {
+		return nil, err
+	}
+	if version != claims.TokenVersion {
+		return nil, ErrTokenRevoked
 	}
 
-	return userIDString, nil
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
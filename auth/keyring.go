@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnknownKey is returned for tokens signed with a key the keyring
+// doesn't hold
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// minRSABits is the smallest RSA key accepted
+const minRSABits = 2048
+
+// key is a key of the keyring. Retired keys have no signKey: they only
+// verify the tokens they signed until those expire.
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// Keyring holds the keys access tokens are signed and verified with,
+// each under its own kid
+type Keyring struct {
+	keys map[string]*key
+	// signing is the active key new tokens are signed with
+	signing *key
+}
+
+// NewHMACKeyring returns a keyring signing with HS256 and secret, for
+// setups without asymmetric keys. Its key isn't published in the JWKS.
+func NewHMACKeyring(secret string) *Keyring {
+	k := &key{method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+	return &Keyring{keys: map[string]*key{"": k}, signing: k}
+}
+
+// LoadKeyring reads the keys of dir. Every *.pem file is a key named
+// after the file: a private key (PKCS #8, or PKCS #1 for RSA) is active,
+// a public key (PKIX) is retired. Ed25519 keys sign with EdDSA, RSA
+// keys with RS256. New tokens are signed with the active key whose name
+// sorts last, so naming keys by date makes the newest one sign.
+func LoadKeyring(dir string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keyring := &Keyring{keys: map[string]*key{}}
+	for _, path := range paths {
+		k, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %v", path, err)
+		}
+		keyring.keys[k.id] = k
+		if k.signKey != nil {
+			keyring.signing = k
+		}
+	}
+	if keyring.signing == nil {
+		return nil, fmt.Errorf("no active key in %s", dir)
+	}
+	return keyring, nil
+}
+
+// RetireHMAC adds secret as a retired HS256 key under the empty kid, so
+// the tokens signed before the keyring replaced NewHMACKeyring stay
+// valid until they expire
+func (kr *Keyring) RetireHMAC(secret string) error {
+	if _, ok := kr.keys[""]; ok {
+		return errors.New("a key without a name is already loaded")
+	}
+	kr.keys[""] = &key{method: jwt.SigningMethodHS256, verifyKey: []byte(secret)}
+	return nil
+}
+
+func loadKey(path string) (*key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	k := &key{id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodEdDSA, parsed, parsed.Public()
+	case ed25519.PublicKey:
+		k.method, k.verifyKey = jwt.SigningMethodEdDSA, parsed
+	case *rsa.PrivateKey:
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodRS256, parsed, &parsed.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.verifyKey = jwt.SigningMethodRS256, parsed
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if pub, ok := k.verifyKey.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+	}
+	return k, nil
+}
+
+// sign signs claims with the signing key, naming it in the kid header
+func (kr *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.signing.method, claims)
+	if kr.signing.id != "" {
+		token.Header["kid"] = kr.signing.id
+	}
+	return token.SignedString(kr.signing.signKey)
+}
+
+// verifyKey is the jwt.Keyfunc picking the key by the kid of the token.
+// The algorithm must be the key's so a public key can't be used as an
+// HMAC secret.
+func (kr *Keyring) verifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := kr.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return k.verifyKey, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// Crv and X are set for Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring, active and retired, for
+// other services to verify access tokens with. HMAC secrets are never
+// published.
+func (kr *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range kr.keys {
+		jwk := JWK{Kid: k.id, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
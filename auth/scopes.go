@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// Scopes an access token can carry, granted by the role of its user
+const (
+	// ScopeModerate allows working the moderation queue: reports,
+	// flagged chirps, deleting and restoring any chirp
+	ScopeModerate = "chirps:moderate"
+	// ScopeModerationRules allows reading and reloading the moderation
+	// rules
+	ScopeModerationRules = "moderation:rules"
+	ScopeMetrics         = "metrics:read"
+	ScopeReset           = "admin:reset"
+	// ScopeRoles allows changing the role of users
+	ScopeRoles = "users:roles"
+)
+
+var roleScopes = map[string][]string{
+	types.RoleModerator: {ScopeModerate},
+	types.RoleAdmin:     {ScopeModerate, ScopeModerationRules, ScopeMetrics, ScopeReset, ScopeRoles},
+}
+
+// ValidRole reports whether role is one of the roles users can have
+func ValidRole(role string) bool {
+	return role == types.RoleUser || roleScopes[role] != nil
+}
+
+// ScopesForRole returns the scopes granted to role, none for plain users
+func ScopesForRole(role string) []string {
+	return slices.Clone(roleScopes[role])
+}
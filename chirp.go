@@ -7,10 +7,12 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/erwaen/Chirpy/auth"
 	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/moderation"
+	"github.com/erwaen/Chirpy/types"
 )
 
 type returnError struct {
@@ -21,6 +23,95 @@ type returnValid struct {
 	Body string `json:"body"`
 }
 
+// Chirp is the public representation of types.Chirp
+type Chirp struct {
+	ID          int          `json:"id"`
+	Body        string       `json:"body"`
+	AuthorID    int          `json:"author_id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	ParentID    int          `json:"parent_id,omitempty"`
+	Deleted     bool         `json:"deleted,omitempty"`
+	ReplyCount  int          `json:"reply_count"`
+	LikeCount   int          `json:"like_count"`
+	RepostCount int          `json:"repost_count"`
+	Hashtags    []string     `json:"hashtags,omitempty"`
+	Mentions    []int        `json:"mentions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Draft       bool         `json:"draft,omitempty"`
+	PublishAt   *time.Time   `json:"publish_at,omitempty"`
+	// LikedByMe, RepostedByMe and BookmarkedByMe are only set for
+	// requests with a valid bearer token
+	LikedByMe      *bool `json:"liked_by_me,omitempty"`
+	RepostedByMe   *bool `json:"reposted_by_me,omitempty"`
+	BookmarkedByMe *bool `json:"bookmarked_by_me,omitempty"`
+}
+
+func chirpFromDB(chirp types.Chirp) Chirp {
+	resp := Chirp{
+		ID:          chirp.Id,
+		Body:        chirp.Body,
+		AuthorID:    chirp.AuthorID,
+		CreatedAt:   chirp.CreatedAt,
+		UpdatedAt:   chirp.UpdatedAt,
+		ParentID:    chirp.ParentID,
+		Deleted:     chirp.Deleted,
+		Hashtags:    chirp.Hashtags,
+		Mentions:    chirp.Mentions,
+		Attachments: attachmentsFromDB(chirp.Attachments),
+		Draft:       chirp.Draft,
+		PublishAt:   chirp.PublishAt,
+	}
+	// Deleted chirps keep their content until they are purged, so it can
+	// be restored
+	if chirp.Deleted {
+		resp.Body = ""
+		resp.AuthorID = 0
+		resp.Hashtags = nil
+		resp.Mentions = nil
+		resp.Attachments = nil
+	}
+	return resp
+}
+
+// publicChirps converts chirps for a response, filling in their reply,
+// like and repost counts. viewerID is the user making the request, 0
+// when anonymous.
+func (cfg *apiConfig) publicChirps(chirps []types.Chirp, viewerID int) ([]Chirp, error) {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	stats, err := cfg.db.GetChirpStats(ids, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		c := chirpFromDB(chirp)
+		st := stats[chirp.Id]
+		c.ReplyCount = st.Replies
+		c.LikeCount = st.Likes
+		c.RepostCount = st.Reposts
+		if viewerID != 0 {
+			c.LikedByMe = &st.Liked
+			c.RepostedByMe = &st.Reposted
+			c.BookmarkedByMe = &st.Bookmarked
+		}
+		resp = append(resp, c)
+	}
+	return resp, nil
+}
+
+func (cfg *apiConfig) publicChirp(chirp types.Chirp, viewerID int) (Chirp, error) {
+	resp, err := cfg.publicChirps([]types.Chirp{chirp}, viewerID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return resp[0], nil
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	log.Printf(msg)
 	error := returnError{
@@ -63,38 +154,221 @@ func (cfg *apiConfig) handlerReadChirps(w http.ResponseWriter, r *http.Request)
 			}
 			return
 		}
-		respondWithJson(w, http.StatusOK, chirp)
+		viewer := viewerID(r)
+		if !canSee(chirp, viewer) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+			return
+		}
+		resp, err := cfg.publicChirp(chirp, viewer)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("error retrieving chirp: %s", err))
+			return
+		}
+		// Deleted chirps only show as tombstones of their threads
+		if resp.Deleted && resp.ReplyCount == 0 {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+			return
+		}
+		respondWithJson(w, http.StatusOK, resp)
 		return
 	}
 
 	s := r.URL.Query().Get("author_id")
-	sort := r.URL.Query().Get("sort")
 	authorID, err := strconv.Atoi(s)
 	if err != nil {
 		authorID = 0
 	}
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{
+		AuthorID: authorID,
+		Hashtag:  hashtagParam(r),
+	}, false)
+}
+
+func (cfg *apiConfig) handlerChirpReplies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+
+	// Tombstones still list their replies
+	chirp, err := cfg.db.GetChirp(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("error retrieving chirp: %s", err))
+		}
+		return
+	}
+	if !canSee(chirp, viewerID(r)) {
+		respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		return
+	}
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{ParentID: id}, false)
+}
+
+// respondWithChirpList lists the chirps matching q, applying the sort,
+// limit and cursor query parameters of the request. The ordering of q
+// is kept when the request has no sort parameter. Without limit or
+// cursor the whole list is returned, unless alwaysPaginate is set.
+func (cfg *apiConfig) respondWithChirpList(w http.ResponseWriter, r *http.Request, q database.ChirpQuery, alwaysPaginate bool) {
+	type pageResponse struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
 
-	chirps, err := cfg.db.GetChirps(authorID, sort)
+	p, paginated, err := parsePage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !paginated && alwaysPaginate {
+		p.limit, paginated = defaultPageSize, true
+	}
+	if sort := r.URL.Query().Get("sort"); sort != "" || q.OrderBy == "" {
+		q.OrderBy, q.Sort = parseChirpSort(sort)
+	}
+	if paginated {
+		// Ask for one extra chirp to know whether there is a next page
+		q.After = p.after
+		q.Limit = p.limit + 1
+	}
+
+	chirps, err := cfg.db.ListChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting chirps: %s", err))
+		return
+	}
+	resp := pageResponse{}
+	if paginated && len(chirps) > p.limit {
+		chirps = chirps[:p.limit]
+		resp.NextCursor = encodeCursor(q.CursorFor(chirps[p.limit-1]))
+	}
+	resp.Chirps, err = cfg.publicChirps(chirps, viewerID(r))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting chirps: %s", err))
 		return
 	}
-	respondWithJson(w, 200, chirps)
+
+	if !paginated {
+		respondWithJson(w, 200, resp.Chirps)
+		return
+	}
+	setNextLink(w, r, resp.NextCursor)
+	respondWithJson(w, 200, resp)
 }
 
-func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+func (cfg *apiConfig) handlerChirpHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(id)
+	// The history of a deleted chirp holds its content
+	if err == nil && (chirp.Deleted || !canSee(chirp, viewerID(r))) {
+		err = database.ErrNotExist
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("error retrieving chirp history: %s", err))
+		}
+		return
+	}
+
+	history, err := cfg.db.GetChirpHistory(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("error retrieving chirp history: %s", err))
+		}
+		return
+	}
+	if history == nil {
+		history = []types.ChirpVersion{}
+	}
+	respondWithJson(w, http.StatusOK, history)
+}
+
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID := authUser(r).Id
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
 		return
 	}
 
-	userID, err := strconv.Atoi(subject)
+	chirp, err := cfg.db.GetChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+		}
+		return
+	}
+	if chirp.Deleted {
+		respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		return
+	}
+	if chirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not allowed to edit this chirp")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	result, err := cfg.validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashtags, mentions, err := cfg.extractEntities(result.Body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve mentions")
+		return
+	}
+
+	chirp.Body = result.Body
+	chirp.Flagged = result.Flagged
+	chirp.Hashtags = hashtags
+	chirp.Mentions = mentions
+	updated, err := cfg.db.UpdateChirp(chirp)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found when trying to edit")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't edit the chirp")
+		}
+		return
+	}
+	resp, err := cfg.publicChirp(updated, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
 		return
 	}
+	respondWithJson(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
 
 	idString := r.PathValue("id")
 	chirpID, err := strconv.Atoi(idString)
@@ -113,93 +387,109 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if chirp.AuthorID != userID {
+	if chirp.Deleted {
+		respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		return
+	}
+	if chirp.AuthorID != userID && !claimsFromContext(r.Context()).HasScope(auth.ScopeModerate) {
 		respondWithError(w, http.StatusForbidden, "You are not allowed to delete this chirp")
 		return
 	}
-	_, err = cfg.db.DeleteChirp(chirpID)
+	_, err = cfg.db.DeleteChirp(chirpID, userID)
 	if err != nil {
 		if err == database.ErrNotExist {
 			respondWithError(w, http.StatusNotFound, "Chirp Not found when trying to delete")
 		} else {
 			respondWithError(w, http.StatusInternalServerError, "Couldn't delete the chirp")
 		}
+		return
 	}
 	respondWithoutJson(w, http.StatusNoContent)
 }
 
 func (cfg *apiConfig) handlerNewChirp(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
-	}
-	type response struct {
-		ID       int    `json:"id"`
 		Body     string `json:"body"`
-		AuthorID int    `json:"author_id"`
+		ParentID int    `json:"parent_id"`
+		// Draft keeps the chirp private until it is published
+		Draft bool `json:"draft"`
+		// PublishAt schedules the chirp instead of publishing it now
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
-		return
-	}
+	userID := authUser(r).Id
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, 500, "Couldn't decode parameters")
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	result, err := cfg.validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	if params.PublishAt != nil {
+		if params.Draft {
+			respondWithError(w, http.StatusBadRequest, "A chirp can't be both a draft and scheduled")
+			return
+		}
+		if !params.PublishAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "publish_at must be in the future")
+			return
+		}
+		publishAt := params.PublishAt.UTC()
+		params.PublishAt = &publishAt
+	}
+
+	if params.ParentID != 0 {
+		parent, err := cfg.db.GetChirp(params.ParentID)
+		if err != nil && !errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the parent chirp")
+			return
+		}
+		if err != nil || parent.Deleted || !parent.Published() {
+			respondWithError(w, http.StatusBadRequest, "Parent chirp doesn't exist")
+			return
+		}
+	}
+
+	hashtags, mentions, err := cfg.extractEntities(result.Body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve mentions")
+		return
+	}
+
 	// Save the chirp to the database
-	newChirp, err := cfg.db.CreateChirp(cleaned, userID)
+	newChirp, err := cfg.db.CreateChirp(types.Chirp{
+		Body:      result.Body,
+		AuthorID:  userID,
+		Flagged:   result.Flagged,
+		ParentID:  params.ParentID,
+		Hashtags:  hashtags,
+		Mentions:  mentions,
+		Draft:     params.Draft,
+		PublishAt: params.PublishAt,
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
-	respondWithJson(w, http.StatusCreated, response{
-		ID:       newChirp.Id,
-		Body:     newChirp.Body,
-		AuthorID: newChirp.AuthorID,
-	})
+	respondWithJson(w, http.StatusCreated, chirpFromDB(newChirp))
 }
 
-func validateChirp(body string) (string, error) {
-	const maxChirpLength = 140
-	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
-	}
-
-	BLACK_WORDS := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
-	}
-	cleaned := getCleanedBody(body, BLACK_WORDS)
-	return cleaned, nil
+// canSee reports whether viewerID may read chirp: drafts and scheduled
+// chirps are only visible to their author
+func canSee(chirp types.Chirp, viewerID int) bool {
+	return chirp.Published() || chirp.AuthorID == viewerID
 }
 
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
-		}
-	}
-	cleaned := strings.Join(words, " ")
-	return cleaned
+// validateChirp runs a chirp body through the moderation rules. The
+// returned error is meant for the client.
+func (cfg *apiConfig) validateChirp(body string) (moderation.Result, error) {
+	return cfg.moderator.Check(body)
 }
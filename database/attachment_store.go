@@ -0,0 +1,37 @@
+package database
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// MaxAttachments is the number of attachments a chirp can have
+const MaxAttachments = 4
+
+var ErrTooManyAttachments = errors.New("Chirp already has the maximum number of attachments")
+
+// AddAttachment appends attachment to a chirp
+func (db *DB) AddAttachment(chirpID int, attachment types.Attachment) (types.Chirp, error) {
+	var updated types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted {
+			return ErrNotExist
+		}
+		if len(chirp.Attachments) >= MaxAttachments {
+			return ErrTooManyAttachments
+		}
+		// Clip so appending never writes into the slice shared with the
+		// previous state
+		chirp.Attachments = append(slices.Clip(chirp.Attachments), attachment)
+		chirpsTable.put(dbStructure, chirpID, chirp)
+		updated = chirp
+		return nil
+	})
+	if err != nil {
+		return types.Chirp{}, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+func bookmarkKey(userID, chirpID int) string {
+	return fmt.Sprintf("%d:%d", userID, chirpID)
+}
+
+// AddBookmark saves a chirp for userID. Bookmarking twice is not an
+// error.
+func (db *DB) AddBookmark(userID, chirpID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !chirp.Published() {
+			return ErrNotExist
+		}
+		key := bookmarkKey(userID, chirpID)
+		if _, ok := dbStructure.Bookmarks[key]; ok {
+			return nil
+		}
+		bookmarksTable.put(dbStructure, key, types.Bookmark{
+			UserID:    userID,
+			ChirpID:   chirpID,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+// RemoveBookmark undoes AddBookmark. Removing a bookmark that doesn't
+// exist is not an error.
+func (db *DB) RemoveBookmark(userID, chirpID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return ErrNotExist
+		}
+		bookmarksTable.del(dbStructure, bookmarkKey(userID, chirpID))
+		return nil
+	})
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// Fields chirps can be ordered by
+const (
+	OrderByID        = "id"
+	OrderByCreatedAt = "created_at"
+	OrderByUpdatedAt = "updated_at"
+	OrderByDeletedAt = "deleted_at"
+	// OrderByBookmarkedAt orders the chirps of a BookmarkedBy query by
+	// when they were bookmarked
+	OrderByBookmarkedAt = "bookmarked_at"
+)
+
+// ChirpQuery selects a page of chirps
+type ChirpQuery struct {
+	// AuthorID limits the result to one author, 0 means every author
+	AuthorID int
+	// FollowedBy limits the result to the authors this user follows, 0
+	// means every author
+	FollowedBy int
+	// BookmarkedBy limits the result to the chirps this user bookmarked,
+	// 0 means every chirp
+	BookmarkedBy int
+	// ParentID only returns the replies to this chirp, 0 means every
+	// chirp. Deleted replies that have replies of their own are returned
+	// as tombstones so the rest of the thread stays reachable; other
+	// queries never return deleted chirps.
+	ParentID int
+	// Hashtag only returns chirps with this lowercased tag, "" means
+	// every chirp
+	Hashtag string
+	// Unpublished returns the drafts and scheduled chirps instead of the
+	// published ones
+	Unpublished bool
+	// Flagged only returns chirps flagged for review
+	Flagged bool
+	// Deleted returns the deleted chirps that can still be restored
+	// instead of the visible ones
+	Deleted bool
+	// OrderBy is OrderByID (the default), OrderByCreatedAt,
+	// OrderByUpdatedAt, for Deleted queries OrderByDeletedAt or, for
+	// BookmarkedBy queries, OrderByBookmarkedAt. Ties on time are broken
+	// by ID.
+	OrderBy string
+	// Sort is "asc" (the default) or "desc"
+	Sort string
+	// After is the position of the last chirp of the previous page. The
+	// zero value starts at the first page.
+	After ChirpCursor
+	// Limit caps the number of chirps returned, 0 means no limit
+	Limit int
+}
+
+// ChirpCursor is the position of a chirp in a listing. Time is only
+// used when ordering by time.
+type ChirpCursor struct {
+	ID   int
+	Time time.Time
+}
+
+// CursorFor returns the cursor pointing at chirp in the order of q
+func (q ChirpQuery) CursorFor(chirp types.Chirp) ChirpCursor {
+	q = q.normalize()
+	return ChirpCursor{ID: chirp.Id, Time: q.sortTime(chirp)}
+}
+
+// normalize replaces invalid ordering options by the defaults
+func (q ChirpQuery) normalize() ChirpQuery {
+	switch {
+	case q.OrderBy == OrderByCreatedAt, q.OrderBy == OrderByUpdatedAt:
+	case q.OrderBy == OrderByDeletedAt && q.Deleted:
+	case q.OrderBy == OrderByBookmarkedAt && q.BookmarkedBy != 0:
+	default:
+		q.OrderBy = OrderByID
+	}
+	if q.Sort != "asc" && q.Sort != "desc" {
+		q.Sort = "asc"
+	}
+	return q
+}
+
+func (q ChirpQuery) sortTime(chirp types.Chirp) time.Time {
+	switch q.OrderBy {
+	case OrderByCreatedAt:
+		return chirp.CreatedAt
+	case OrderByUpdatedAt:
+		return chirp.UpdatedAt
+	case OrderByDeletedAt:
+		if chirp.DeletedAt != nil {
+			return *chirp.DeletedAt
+		}
+	case OrderByBookmarkedAt:
+		if chirp.BookmarkedAt != nil {
+			return *chirp.BookmarkedAt
+		}
+	}
+	return time.Time{}
+}
+
+// compare orders a before b following q, ascending
+func (q ChirpQuery) compare(a ChirpCursor, b ChirpCursor) int {
+	if q.OrderBy != OrderByID {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
+
+// less reports whether a sorts before b. q must be normalized.
+func (q ChirpQuery) less(a, b types.Chirp) bool {
+	c := q.compare(q.CursorFor(a), q.CursorFor(b))
+	if q.Sort == "desc" {
+		return c > 0
+	}
+	return c < 0
+}
+
+// pastCursor reports whether chirp comes after q.After. q must be
+// normalized.
+func (q ChirpQuery) pastCursor(chirp types.Chirp) bool {
+	if q.After.ID == 0 {
+		return true
+	}
+	c := q.compare(q.CursorFor(chirp), q.After)
+	if q.Sort == "desc" {
+		return c < 0
+	}
+	return c > 0
+}
@@ -4,182 +4,413 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/erwaen/Chirpy/types"
+	"log"
 	"os"
+	"slices"
 	"sort"
 	"sync"
+	"time"
 )
 
 var ErrNotExist = errors.New("resource does not exist")
 
+var errEmptyFile = errors.New("database file is empty")
+
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path       string
+	mux        *sync.RWMutex
+	opts       Options
+	lastBackup time.Time
+
+	// data is the whole database kept in memory. Update changes it in
+	// place under the write lock, so readers holding the read lock see
+	// a consistent state.
+	data       DBStructure
+	wal        *os.File
+	walRecords int
+
+	indexes       []index
+	replies       *replyIndex
+	reactions     *reactionIndex
+	follows       *followIndex
+	notifications *notificationIndex
+	search        *searchIndex
+	bookmarks     *bookmarkIndex
 }
 
+// DBStructure is the full content of the database. Collections must be
+// flat maps registered in tables so their changes can be recorded in the
+// write-ahead log.
 type DBStructure struct {
 	Chirps        map[int]types.Chirp           `json:"chirps"`
 	Users         map[int]types.User            `json:"users"`
 	RefreshTokens map[string]types.RefreshToken `json:"refresh_tokens"`
+	// ChirpHistory holds the previous versions of edited chirps, oldest
+	// first
+	ChirpHistory map[int][]types.ChirpVersion `json:"chirp_history"`
+	// Reactions are keyed by "kind:chirpID:userID"
+	Reactions map[string]types.Reaction `json:"reactions"`
+	// Follows are keyed by "followerID:followeeID"
+	Follows       map[string]types.Follow    `json:"follows"`
+	Notifications map[int]types.Notification `json:"notifications"`
+	// Bookmarks are keyed by "userID:chirpID"
+	Bookmarks map[string]types.Bookmark `json:"bookmarks"`
+	Reports   map[int]types.Report      `json:"reports"`
+	// NextIDs holds the next ID of the chirps, notifications and reports,
+	// keyed by table name, so the IDs of purged entries aren't given to
+	// new ones
+	NextIDs map[string]int `json:"next_ids"`
+
+	// journal records the changes of the running Update
+	journal *journal
 }
 
 func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps:        map[int]types.Chirp{},
-		Users:         map[int]types.User{},
-		RefreshTokens: map[string]types.RefreshToken{},
-	}
-	return db.writeDB(dbStructure)
+	db.data = DBStructure{}
+	db.data.ensureMaps()
+	return db.writeFile(db.data)
 }
 
 // NewDB creates a new database connection
 // and creates the database file if it doesn't exist
 func NewDB(path string) (*DB, error) {
+	return NewDBWithOptions(path, Options{
+		Backups:        DefaultBackups,
+		BackupInterval: DefaultBackupInterval,
+		CompactEvery:   DefaultCompactEvery,
+	})
+}
+
+// NewDBWithOptions is NewDB with control over backups and compaction
+func NewDBWithOptions(path string, opts Options) (*DB, error) {
 	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:          path,
+		mux:           &sync.RWMutex{},
+		opts:          opts,
+		replies:       &replyIndex{},
+		reactions:     &reactionIndex{},
+		follows:       &followIndex{},
+		notifications: &notificationIndex{},
+		search:        &searchIndex{},
+		bookmarks:     &bookmarkIndex{},
+	}
+	db.indexes = []index{db.replies, db.reactions, db.follows, db.notifications, db.search, db.bookmarks}
+	db.removeTempFiles()
+	if err := db.ensureDB(); err != nil {
+		return db, err
+	}
+	if err := db.openWAL(); err != nil {
+		return db, err
 	}
-	err := db.ensureDB()
-	return db, err
+	db.rebuildIndexes()
+	return db, nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
+// ensureDB loads the snapshot into memory, creating it if it doesn't
+// exist and restoring the newest valid backup if it can't be parsed
 func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
+	dbStructure, err := db.readFile()
 	if errors.Is(err, os.ErrNotExist) {
 		return db.createDB()
 	}
-	return err
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, errEmptyFile) {
+		if err := db.recoverDB(err); err != nil {
+			return err
+		}
+		dbStructure, err = db.readFile()
+	}
+	if err != nil {
+		return err
+	}
+	db.data = dbStructure
+	return nil
 }
 
 func (db *DB) ResetDB() error {
-	err := os.Remove(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
-	}
-	return db.ensureDB()
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	db.data = DBStructure{}
+	db.data.ensureMaps()
+	db.rebuildIndexes()
+	return db.compact()
 }
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (DBStructure, error) {
+// View runs fn against the in-memory database while holding the read
+// lock. fn must not modify dbStructure.
+func (db *DB) View(fn func(*DBStructure) error) error {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
+	return fn(&db.data)
+}
+
+// Update lets fn mutate the database while holding the write lock, so
+// concurrent read-modify-write calls can't overwrite each other. fn
+// writes entries with the put and del methods of their table. If fn
+// succeeds the changed entries are appended to the write-ahead log;
+// if it returns an error, or the log can't be written, its changes are
+// rolled back. The snapshot file is rewritten every CompactEvery
+// updates.
+func (db *DB) Update(fn func(*DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	j := newJournal()
+	db.data.journal = j
+	err := fn(&db.data)
+	db.data.journal = nil
+	if err != nil {
+		j.rollback(&db.data)
+		return err
+	}
+	ops, err := j.ops(&db.data)
+	if err != nil {
+		j.rollback(&db.data)
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	err = db.appendWAL(ops)
+	if err != nil {
+		j.rollback(&db.data)
+		return err
+	}
+	db.updateIndexes(&j.before, &db.data, ops)
 
+	if db.opts.CompactEvery > 0 && db.walRecords >= db.opts.CompactEvery {
+		// The update is already durable in the log, a failed compaction
+		// only means the next one has more to do
+		if err := db.compact(); err != nil {
+			log.Printf("Error compacting database: %s", err)
+		}
+	}
+	return nil
+}
+
+// readFile and writeFile do the snapshot I/O; callers must hold db.mux
+func (db *DB) readFile() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
-	if errors.Is(err, os.ErrNotExist) {
+	if err != nil {
 		return dbStructure, err
 	}
+	if len(dat) == 0 {
+		return dbStructure, errEmptyFile
+	}
 	err = json.Unmarshal(dat, &dbStructure)
 	if err != nil {
 		return dbStructure, err
 	}
+	dbStructure.ensureMaps()
 	return dbStructure, nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
+func (db *DB) writeFile(dbStructure DBStructure) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(db.path, dat, 0600)
+	err = db.backup()
 	if err != nil {
 		return err
 	}
-	return nil
+	return atomicWriteFile(db.path, dat, 0600)
 }
 
 // GetChirps returns all chirps in the database
 func (db *DB) GetChirps(authorID int, sortBy string) ([]types.Chirp, error) {
-	chirps, err := db.loadDB()
-	if err != nil {
-		return []types.Chirp{}, err
-	}
+	return db.ListChirps(ChirpQuery{AuthorID: authorID, Sort: sortBy})
+}
 
-	var chirpList []types.Chirp
+// ListChirps returns the chirps matching q
+func (db *DB) ListChirps(q ChirpQuery) ([]types.Chirp, error) {
+	q = q.normalize()
 
-	for _, chirp := range chirps.Chirps {
-		if authorID == 0 || chirp.AuthorID == authorID {
+	var chirpList []types.Chirp
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, chirp := range dbStructure.Chirps {
+			if q.Hashtag != "" && !slices.Contains(chirp.Hashtags, q.Hashtag) {
+				continue
+			}
+			if q.ParentID != 0 && chirp.ParentID != q.ParentID {
+				continue
+			}
+			if q.Deleted {
+				if !chirp.Restorable() {
+					continue
+				}
+			} else if chirp.Published() == q.Unpublished {
+				continue
+			} else if chirp.Deleted && (q.ParentID == 0 || !db.replies.hasReplies(chirp.Id)) {
+				continue
+			}
+			if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+				continue
+			}
+			if q.FollowedBy != 0 && !db.follows.following[q.FollowedBy][chirp.AuthorID] {
+				continue
+			}
+			if q.BookmarkedBy != 0 {
+				bookmark, ok := dbStructure.Bookmarks[bookmarkKey(q.BookmarkedBy, chirp.Id)]
+				if !ok {
+					continue
+				}
+				chirp.BookmarkedAt = &bookmark.CreatedAt
+			}
+			if q.Flagged && !chirp.Flagged {
+				continue
+			}
+			if !q.pastCursor(chirp) {
+				continue
+			}
 			chirpList = append(chirpList, chirp)
 		}
+		return nil
+	})
+	if err != nil {
+		return []types.Chirp{}, err
 	}
-	// Set default sort order if invalid
-	if sortBy != "asc" && sortBy != "desc" {
-		sortBy = "asc"
-	}
+
 	sort.Slice(chirpList, func(i, j int) bool {
-		if sortBy == "asc" {
-			return chirpList[i].Id < chirpList[j].Id
-		}
-		return chirpList[i].Id > chirpList[j].Id
+		return q.less(chirpList[i], chirpList[j])
 	})
+	if q.Limit > 0 && len(chirpList) > q.Limit {
+		chirpList = chirpList[:q.Limit]
+	}
 
 	return chirpList, nil
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, authorID int) (types.Chirp, error) {
-
-	chirps, err := db.loadDB()
-	if err != nil {
-		return types.Chirp{}, err
-	}
-
-	newID := 0
-	for id := range chirps.Chirps {
-		if id > newID {
-			newID = id
+// nextID returns a new ID for an entry of t, never given before. Files
+// from before NextIDs continue after the highest ID in use.
+func nextID[V any](dbStructure *DBStructure, t mapTable[int, V]) int {
+	id := dbStructure.NextIDs[t.tableName]
+	if id == 0 {
+		id = 1
+		for existing := range *t.field(dbStructure) {
+			if existing >= id {
+				id = existing + 1
+			}
 		}
 	}
-	newID++
-	newChirp := types.Chirp{
-		Id:       newID,
-		Body:     body,
-		AuthorID: authorID,
-	}
+	nextIDsTable.put(dbStructure, t.tableName, id+1)
+	return id
+}
 
-	chirps.Chirps[newID] = newChirp
-	err = db.writeDB(chirps)
+// CreateChirp saves chirp to disk with a new ID and creation time
+func (db *DB) CreateChirp(chirp types.Chirp) (types.Chirp, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		newID := nextID(dbStructure, chirpsTable)
+		now := time.Now().UTC()
+		chirp.Id = newID
+		chirp.CreatedAt = now
+		chirp.UpdatedAt = now
+		chirpsTable.put(dbStructure, newID, chirp)
+		if chirp.Published() {
+			notifyMentions(dbStructure, chirp, nil)
+		}
+		return nil
+	})
 	if err != nil {
 		return types.Chirp{}, err
 	}
-	return newChirp, nil
-
+	return chirp, nil
 }
 
 func (db *DB) GetChirp(id int) (types.Chirp, error) {
-	dbStructure, err := db.loadDB()
+	var chirp types.Chirp
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return types.Chirp{}, err
 	}
-
-	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return types.Chirp{}, ErrNotExist
-	}
-
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(id int) (types.Chirp, error) {
-	dbStructure, err := db.loadDB()
+// DeleteChirp hides a chirp, keeping everything about it so it can be
+// restored until PurgeDeletedChirps removes it for good
+func (db *DB) DeleteChirp(id, deletedBy int) (types.Chirp, error) {
+	var deletedChirp types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.Deleted {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		chirp.Deleted = true
+		chirp.DeletedAt = &now
+		chirp.DeletedBy = deletedBy
+		chirpsTable.put(dbStructure, id, chirp)
+		deletedChirp = chirp
+		return nil
+	})
 	if err != nil {
 		return types.Chirp{}, err
 	}
+	return deletedChirp, nil
+}
 
-	deletedChirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return types.Chirp{}, ErrNotExist
-	}
+// UpdateChirp saves the editable fields of chirp and keeps the previous
+// body in its history when it changed
+func (db *DB) UpdateChirp(chirp types.Chirp) (types.Chirp, error) {
+	var updated types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Chirps[chirp.Id]
+		if !ok {
+			return ErrNotExist
+		}
+		if stored.Body != chirp.Body {
+			// Clip so appending never writes into the slice shared with
+			// the previous state
+			history := slices.Clip(dbStructure.ChirpHistory[chirp.Id])
+			history = append(history, types.ChirpVersion{
+				ChirpID:   chirp.Id,
+				Version:   len(history) + 1,
+				Body:      stored.Body,
+				CreatedAt: stored.UpdatedAt,
+			})
+			chirpHistoryTable.put(dbStructure, chirp.Id, history)
+		}
 
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
+		updated = stored
+		updated.Body = chirp.Body
+		updated.Flagged = chirp.Flagged
+		updated.Hashtags = chirp.Hashtags
+		updated.Mentions = chirp.Mentions
+		updated.UpdatedAt = time.Now().UTC()
+		chirpsTable.put(dbStructure, chirp.Id, updated)
+		if updated.Published() {
+			notifyMentions(dbStructure, updated, stored.Mentions)
+		}
+		return nil
+	})
 	if err != nil {
 		return types.Chirp{}, err
 	}
+	return updated, nil
+}
 
-	return deletedChirp, nil
+// GetChirpHistory returns the previous versions of a chirp, oldest first
+func (db *DB) GetChirpHistory(id int) ([]types.ChirpVersion, error) {
+	var history []types.ChirpVersion
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[id]; !ok {
+			return ErrNotExist
+		}
+		history = slices.Clone(dbStructure.ChirpHistory[id])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
 }
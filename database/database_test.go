@@ -0,0 +1,564 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+func newTestDB(t testing.TB, opts Options) *DB {
+	t.Helper()
+	db, err := NewDBWithOptions(filepath.Join(t.TempDir(), "database.json"), opts)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.wal.Close() })
+	return db
+}
+
+// TestConcurrentUpdates hammers the mutators from many goroutines so
+// `go test -race` catches unsynchronized access, then checks that no
+// update was lost, in memory and after reloading from disk
+func TestConcurrentUpdates(t *testing.T) {
+	const (
+		workers         = 8
+		chirpsPerWorker = 20
+		tokensPerWorker = 10
+	)
+	// Compact often so snapshots are written while updates go on
+	db := newTestDB(t, Options{CompactEvery: 25})
+
+	users := make([]types.User, workers)
+	for i := range users {
+		user, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users[i] = user
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user types.User) {
+			defer wg.Done()
+			errs <- func() error {
+				for n := 0; n < chirpsPerWorker; n++ {
+					chirp, err := db.CreateChirp(types.Chirp{Body: fmt.Sprintf("chirp %d", n), AuthorID: user.Id})
+					if err != nil {
+						return fmt.Errorf("CreateChirp: %v", err)
+					}
+					if n%2 == 1 {
+						if _, err := db.DeleteChirp(chirp.Id, user.Id); err != nil {
+							return fmt.Errorf("DeleteChirp: %v", err)
+						}
+					}
+				}
+				if _, err := db.UpdateUser(user.Id, fmt.Sprintf("renamed%d@example.com", i), "newhash"); err != nil {
+					return fmt.Errorf("UpdateUser: %v", err)
+				}
+				if _, err := db.UpgradeUserRed(user.Id); err != nil {
+					return fmt.Errorf("UpgradeUserRed: %v", err)
+				}
+				for n := 0; n < tokensPerWorker; n++ {
+					token := fmt.Sprintf("token-%d-%d", i, n)
+					if _, err := db.InsertRefreshToken(user.Id, token, time.Hour, "test", "127.0.0.1"); err != nil {
+						return fmt.Errorf("InsertRefreshToken: %v", err)
+					}
+					if n%2 == 1 {
+						if _, err := db.RevokeRefreshToken(token); err != nil {
+							return fmt.Errorf("RevokeRefreshToken: %v", err)
+						}
+					}
+				}
+				return nil
+			}()
+		}(i, user)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T, db *DB) {
+		var chirps, deleted int
+		ids := map[int]bool{}
+		err := db.View(func(dbStructure *DBStructure) error {
+			for id, chirp := range dbStructure.Chirps {
+				if id != chirp.Id || ids[id] {
+					return fmt.Errorf("chirp %d stored under ID %d twice", chirp.Id, id)
+				}
+				ids[id] = true
+				chirps++
+				if chirp.Deleted {
+					deleted++
+				}
+			}
+			if len(dbStructure.RefreshTokens) != workers*tokensPerWorker/2 {
+				return fmt.Errorf("got %d refresh tokens, want %d", len(dbStructure.RefreshTokens), workers*tokensPerWorker/2)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirps != workers*chirpsPerWorker {
+			t.Errorf("got %d chirps, want %d", chirps, workers*chirpsPerWorker)
+		}
+		if deleted != workers*chirpsPerWorker/2 {
+			t.Errorf("got %d deleted chirps, want %d", deleted, workers*chirpsPerWorker/2)
+		}
+		for id := 1; id <= workers*chirpsPerWorker; id++ {
+			if !ids[id] {
+				t.Errorf("chirp IDs have a gap at %d", id)
+			}
+		}
+
+		for i, user := range users {
+			got, err := db.GetUserByID(user.Id)
+			if err != nil {
+				t.Fatalf("GetUserByID: %v", err)
+			}
+			if got.Email != fmt.Sprintf("renamed%d@example.com", i) || !got.IsChirpyRed {
+				t.Errorf("user %d = %+v, want renamed and upgraded", user.Id, got)
+			}
+			for n := 0; n < tokensPerWorker; n++ {
+				_, err := db.GetRefreshTokenStruct(fmt.Sprintf("token-%d-%d", i, n))
+				if revoked := n%2 == 1; revoked != errors.Is(err, ErrNotExist) {
+					t.Errorf("token %d of user %d: got error %v, revoked %v", n, user.Id, err, revoked)
+				}
+			}
+		}
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		check(t, db)
+	})
+	t.Run("reloaded", func(t *testing.T) {
+		check(t, reloadTestDB(t, db))
+	})
+}
+
+// TestUpdateRollback checks that a failed Update leaves no trace, in
+// memory or in the write-ahead log
+func TestUpdateRollback(t *testing.T) {
+	db := newTestDB(t, Options{})
+	chirp, err := db.CreateChirp(types.Chirp{Body: "before", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+
+	errFailed := errors.New("failed")
+	err = db.Update(func(dbStructure *DBStructure) error {
+		changed := chirp
+		changed.Body = "after"
+		chirpsTable.put(dbStructure, chirp.Id, changed)
+		reply := types.Chirp{Id: chirp.Id + 1, Body: "reply", AuthorID: 1, ParentID: chirp.Id}
+		chirpsTable.put(dbStructure, reply.Id, reply)
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Update returned %v, want %v", err, errFailed)
+	}
+
+	for name, db := range map[string]*DB{"in memory": db, "reloaded": reloadTestDB(t, db)} {
+		chirps, err := db.ListChirps(ChirpQuery{})
+		if err != nil {
+			t.Fatalf("%s: ListChirps: %v", name, err)
+		}
+		if len(chirps) != 1 || chirps[0].Body != "before" {
+			t.Errorf("%s: got %+v, want only the chirp from before the update", name, chirps)
+		}
+		if n := len(db.replies.children); n != 0 {
+			t.Errorf("%s: reply index has %d entries", name, n)
+		}
+	}
+}
+
+func reloadTestDB(t testing.TB, db *DB) *DB {
+	t.Helper()
+	reloaded, err := NewDBWithOptions(db.path, Options{})
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { reloaded.wal.Close() })
+	return reloaded
+}
+
+// seedTestDB writes a snapshot holding n chirps and opens it
+func seedTestDB(b *testing.B, n int) *DB {
+	b.Helper()
+	dbStructure := DBStructure{}
+	dbStructure.ensureMaps()
+	now := time.Now().UTC()
+	for id := 1; id <= n; id++ {
+		dbStructure.Chirps[id] = types.Chirp{
+			Id:        id,
+			Body:      fmt.Sprintf("chirp number %d with a few more words in it", id),
+			AuthorID:  id%100 + 1,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+	dat, err := json.Marshal(dbStructure)
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "database.json")
+	if err := os.WriteFile(path, dat, 0600); err != nil {
+		b.Fatal(err)
+	}
+	db, err := NewDBWithOptions(path, Options{})
+	if err != nil {
+		b.Fatalf("NewDB: %v", err)
+	}
+	b.Cleanup(func() { db.wal.Close() })
+	return db
+}
+
+var benchmarkSizes = []int{1_000, 10_000, 100_000}
+
+// BenchmarkGetChirp shows that reads are served from memory: their
+// latency doesn't grow with the size of the database
+func BenchmarkGetChirp(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("chirps=%d", n), func(b *testing.B) {
+			db := seedTestDB(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.GetChirp(i%n + 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUpdateChirp shows that an update only costs as much as the
+// entries it changes
+func BenchmarkUpdateChirp(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("chirps=%d", n), func(b *testing.B) {
+			db := seedTestDB(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := db.UpdateChirp(types.Chirp{Id: i%n + 1, Body: fmt.Sprintf("edit %d", i)})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestThreadTombstones checks that a deleted reply with visible replies
+// of its own stays in its thread, so the replies below it can be
+// reached, on both backends
+// testStores creates an empty store of each backend, for the tests
+// both must pass
+var testStores = map[string]func(t *testing.T) Store{
+	"json": func(t *testing.T) Store { return newTestDB(t, Options{}) },
+	"sql":  func(t *testing.T) Store { return newTestSQLDB(t) },
+}
+
+func TestThreadTombstones(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			db := newStore(t)
+			create := func(body string, parentID int, draft bool) types.Chirp {
+				t.Helper()
+				chirp, err := db.CreateChirp(types.Chirp{Body: body, AuthorID: 1, ParentID: parentID, Draft: draft})
+				if err != nil {
+					t.Fatalf("CreateChirp: %v", err)
+				}
+				return chirp
+			}
+			a := create("A", 0, false)
+			b := create("B", a.Id, false)
+			create("C", b.Id, false)
+			leaf := create("D", a.Id, false)
+			// E only has a draft below it, which nobody else can see
+			e := create("E", a.Id, false)
+			create("F", e.Id, true)
+			for _, id := range []int{b.Id, leaf.Id, e.Id} {
+				if _, err := db.DeleteChirp(id, 1); err != nil {
+					t.Fatalf("DeleteChirp: %v", err)
+				}
+			}
+
+			replies, err := db.ListChirps(ChirpQuery{ParentID: a.Id})
+			if err != nil {
+				t.Fatalf("ListChirps: %v", err)
+			}
+			if len(replies) != 1 || replies[0].Id != b.Id || !replies[0].Deleted {
+				t.Errorf("got replies %+v, want only the tombstone of B", replies)
+			}
+			replies, err = db.ListChirps(ChirpQuery{ParentID: e.Id})
+			if err != nil {
+				t.Fatalf("ListChirps: %v", err)
+			}
+			if len(replies) != 0 {
+				t.Errorf("got replies %+v of E, want none", replies)
+			}
+			stats, err := db.GetChirpStats([]int{a.Id, e.Id}, 0)
+			if err != nil {
+				t.Fatalf("GetChirpStats: %v", err)
+			}
+			if stats[a.Id].Replies != 1 || stats[e.Id].Replies != 0 {
+				t.Errorf("A has %d replies and E %d, want 1 and 0", stats[a.Id].Replies, stats[e.Id].Replies)
+			}
+			all, err := db.ListChirps(ChirpQuery{})
+			if err != nil {
+				t.Fatalf("ListChirps: %v", err)
+			}
+			if len(all) != 2 {
+				t.Errorf("got %d chirps outside the thread, want 2", len(all))
+			}
+		})
+	}
+}
+
+// TestRecoverCorruptSnapshot checks that a corrupt snapshot is replaced
+// by the newest backup without replaying the write-ahead log, which was
+// written against the lost snapshot
+func TestRecoverCorruptSnapshot(t *testing.T) {
+	// Every second update compacts, backing up the snapshot it replaces
+	db := newTestDB(t, Options{Backups: 2, CompactEvery: 2})
+	for _, body := range []string{"backed up", "in the lost snapshot", "only in the log"} {
+		if _, err := db.CreateChirp(types.Chirp{Body: body, AuthorID: 1}); err != nil {
+			t.Fatalf("CreateChirp: %v", err)
+		}
+	}
+	db.wal.Close()
+	if err := os.WriteFile(db.path, []byte(`{"chirps": {`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := reloadTestDB(t, db)
+	chirps, err := recovered.ListChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatalf("ListChirps: %v", err)
+	}
+	if len(chirps) != 0 {
+		t.Errorf("got chirps %+v, want the empty database of the backup", chirps)
+	}
+	kept, _ := filepath.Glob(db.path + ".corrupt-*.wal")
+	if len(kept) != 1 {
+		t.Fatalf("got write-ahead logs %v kept aside, want one", kept)
+	}
+	if n, err := countRecords(kept[0]); err != nil || n != 1 {
+		t.Errorf("kept write-ahead log has %d records (%v), want 1", n, err)
+	}
+	if n, err := countRecords(recovered.walPath()); err != nil || n != 0 {
+		t.Errorf("new write-ahead log has %d records (%v), want 0", n, err)
+	}
+}
+
+// TestBookmarkOrder checks that bookmarks are listed and paginated by
+// when they were made, not by the age of their chirps
+func TestBookmarkOrder(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			db := newStore(t)
+			var ids []int
+			for _, body := range []string{"A", "B", "C"} {
+				chirp, err := db.CreateChirp(types.Chirp{Body: body, AuthorID: 1})
+				if err != nil {
+					t.Fatalf("CreateChirp: %v", err)
+				}
+				ids = append(ids, chirp.Id)
+			}
+			// The SQL store keeps milliseconds
+			for _, id := range []int{ids[1], ids[0], ids[2]} {
+				time.Sleep(2 * time.Millisecond)
+				if err := db.AddBookmark(7, id); err != nil {
+					t.Fatalf("AddBookmark: %v", err)
+				}
+			}
+
+			q := ChirpQuery{BookmarkedBy: 7, OrderBy: OrderByBookmarkedAt, Sort: "desc", Limit: 1}
+			var got []int
+			for {
+				page, err := db.ListChirps(q)
+				if err != nil {
+					t.Fatalf("ListChirps: %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				got = append(got, page[0].Id)
+				q.After = q.CursorFor(page[0])
+			}
+			if want := []int{ids[2], ids[0], ids[1]}; !slices.Equal(got, want) {
+				t.Errorf("got bookmarks %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestPurgeRacingRestore checks that a chirp restored while a purge is
+// running is either restored or purged, never both
+func TestPurgeRacingRestore(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			db := newStore(t)
+			var ids []int
+			for i := 0; i < 100; i++ {
+				chirp, err := db.CreateChirp(types.Chirp{Body: "soon gone", AuthorID: 1})
+				if err != nil {
+					t.Fatalf("CreateChirp: %v", err)
+				}
+				if _, err := db.DeleteChirp(chirp.Id, 1); err != nil {
+					t.Fatalf("DeleteChirp: %v", err)
+				}
+				ids = append(ids, chirp.Id)
+			}
+
+			var purged []types.Chirp
+			var purgeErr error
+			restoreErrs := map[int]error{}
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				purged, purgeErr = db.PurgeDeletedChirps(time.Now().Add(time.Minute))
+			}()
+			go func() {
+				defer wg.Done()
+				for _, id := range ids {
+					_, restoreErrs[id] = db.RestoreChirp(id)
+				}
+			}()
+			wg.Wait()
+			if purgeErr != nil {
+				t.Fatalf("PurgeDeletedChirps: %v", purgeErr)
+			}
+
+			wasPurged := map[int]bool{}
+			for _, chirp := range purged {
+				wasPurged[chirp.Id] = true
+			}
+			for _, id := range ids {
+				_, getErr := db.GetChirp(id)
+				switch restoreErr := restoreErrs[id]; {
+				case restoreErr == nil && (wasPurged[id] || getErr != nil):
+					t.Errorf("chirp %d was restored but purged: %v, GetChirp: %v", id, wasPurged[id], getErr)
+				case errors.Is(restoreErr, ErrNotExist) && (!wasPurged[id] || !errors.Is(getErr, ErrNotExist)):
+					t.Errorf("chirp %d wasn't restored but purged: %v, GetChirp: %v", id, wasPurged[id], getErr)
+				case restoreErr != nil && !errors.Is(restoreErr, ErrNotExist):
+					t.Fatalf("RestoreChirp: %v", restoreErr)
+				}
+			}
+		})
+	}
+}
+
+// TestPurgeKeepsIDs checks that the IDs of purged chirps and reports
+// aren't given to new ones, which would inherit their links
+func TestPurgeKeepsIDs(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			db := newStore(t)
+			if _, err := db.CreateChirp(types.Chirp{Body: "stays", AuthorID: 1}); err != nil {
+				t.Fatalf("CreateChirp: %v", err)
+			}
+			gone, err := db.CreateChirp(types.Chirp{Body: "soon gone", AuthorID: 1})
+			if err != nil {
+				t.Fatalf("CreateChirp: %v", err)
+			}
+			report, err := db.CreateReport(gone.Id, 2, "spam")
+			if err != nil {
+				t.Fatalf("CreateReport: %v", err)
+			}
+			if _, err := db.DeleteChirp(gone.Id, 1); err != nil {
+				t.Fatalf("DeleteChirp: %v", err)
+			}
+			if _, err := db.PurgeDeletedChirps(time.Now().Add(time.Minute)); err != nil {
+				t.Fatalf("PurgeDeletedChirps: %v", err)
+			}
+			if reports, err := db.ListReports(""); err != nil || len(reports) != 0 {
+				t.Errorf("got reports %+v, %v, want the report of the purged chirp gone", reports, err)
+			}
+
+			if jsonDB, ok := db.(*DB); ok {
+				db = reloadTestDB(t, jsonDB)
+			}
+			chirp, err := db.CreateChirp(types.Chirp{Body: "new", AuthorID: 1})
+			if err != nil {
+				t.Fatalf("CreateChirp: %v", err)
+			}
+			if chirp.Id <= gone.Id {
+				t.Errorf("new chirp got ID %d, want more than the purged %d", chirp.Id, gone.Id)
+			}
+			newReport, err := db.CreateReport(chirp.Id, 2, "spam")
+			if err != nil {
+				t.Fatalf("CreateReport: %v", err)
+			}
+			if newReport.ID <= report.ID {
+				t.Errorf("new report got ID %d, want more than the purged %d", newReport.ID, report.ID)
+			}
+		})
+	}
+}
+
+// TestRefreshTokenReuse checks that a refresh token can only be rotated
+// once, even by two requests at the same time, and that using it again
+// revokes every token rotated from the same login
+func TestRefreshTokenReuse(t *testing.T) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			db := newStore(t)
+			user, err := db.CreateUser("walter@example.com", "hash")
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if _, err := db.InsertRefreshToken(user.Id, "login", time.Hour, "", ""); err != nil {
+				t.Fatalf("InsertRefreshToken: %v", err)
+			}
+
+			errs := make([]error, 2)
+			var wg sync.WaitGroup
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = db.RotateRefreshToken("login", fmt.Sprintf("rotated-%d", i), time.Hour, "", "")
+				}(i)
+			}
+			wg.Wait()
+			rotated := -1
+			for i, err := range errs {
+				switch {
+				case err == nil && rotated == -1:
+					rotated = i
+				case !errors.Is(err, ErrTokenReused):
+					t.Fatalf("rotations returned %v, want one success and ErrTokenReused", errs)
+				}
+			}
+			if rotated == -1 {
+				t.Fatalf("rotations returned %v, want one success", errs)
+			}
+
+			// The reuse revoked the token the other rotation got
+			winner := fmt.Sprintf("rotated-%d", rotated)
+			if _, err := db.RotateRefreshToken(winner, "again", time.Hour, "", ""); !errors.Is(err, ErrNotExist) {
+				t.Errorf("rotating %s after the reuse: got %v, want ErrNotExist", winner, err)
+			}
+			if sessions, err := db.GetSessions(user.Id); err != nil || len(sessions) != 0 {
+				t.Errorf("sessions after the reuse = %+v, %v, want none", sessions, err)
+			}
+		})
+	}
+}
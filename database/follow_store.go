@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+var ErrSelfFollow = errors.New("Users can't follow themselves")
+
+func followKey(followerID, followeeID int) string {
+	return fmt.Sprintf("%d:%d", followerID, followeeID)
+}
+
+// Follow makes followerID follow followeeID. Following twice is not an
+// error.
+func (db *DB) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrSelfFollow
+	}
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+		key := followKey(followerID, followeeID)
+		if _, ok := dbStructure.Follows[key]; ok {
+			return nil
+		}
+		followsTable.put(dbStructure, key, types.Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+// Unfollow undoes Follow. Unfollowing a user that isn't followed is not
+// an error.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+		followsTable.del(dbStructure, followKey(followerID, followeeID))
+		return nil
+	})
+}
+
+// GetFollowers returns the users following userID, ordered by ID
+func (db *DB) GetFollowers(userID int) ([]types.User, error) {
+	return db.followUsers(userID, db.follows.followers)
+}
+
+// GetFollowing returns the users userID follows, ordered by ID
+func (db *DB) GetFollowing(userID int) ([]types.User, error) {
+	return db.followUsers(userID, db.follows.following)
+}
+
+func (db *DB) followUsers(userID int, edges map[int]map[int]bool) ([]types.User, error) {
+	users := []types.User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrNotExist
+		}
+		for id := range edges[userID] {
+			if user, ok := dbStructure.Users[id]; ok {
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(users, func(a, b types.User) int { return a.Id - b.Id })
+	return users, nil
+}
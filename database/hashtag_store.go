@@ -0,0 +1,53 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// HashtagCount is the number of chirps using a hashtag
+type HashtagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// sortTrending orders counts by decreasing count, then by tag, and keeps
+// the first limit ones
+func sortTrending(counts []HashtagCount, limit int) []HashtagCount {
+	slices.SortFunc(counts, func(a, b HashtagCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+// TrendingHashtags returns the hashtags used by the most chirps created
+// since the given time, at most limit of them
+func (db *DB) TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error) {
+	byTag := map[string]int{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.Deleted || !chirp.Published() || chirp.CreatedAt.Before(since) {
+				continue
+			}
+			for _, tag := range chirp.Hashtags {
+				byTag[tag]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]HashtagCount, 0, len(byTag))
+	for tag, n := range byTag {
+		counts = append(counts, HashtagCount{Tag: tag, Count: n})
+	}
+	return sortTrending(counts, limit), nil
+}
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"encoding/json"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// index is data derived from the collections that only lives in
+// memory. Indexes are rebuilt when the database is loaded and updated
+// after every committed Update, under the write lock.
+type index interface {
+	rebuild(data *DBStructure)
+	// apply updates the index for one committed op; old holds the
+	// entries the Update touched as they were before it, next is the
+	// state after it
+	apply(old, next *DBStructure, op walOp)
+}
+
+// rebuildIndexes fills every index from db.data. Callers must hold the
+// write lock.
+func (db *DB) rebuildIndexes() {
+	for _, idx := range db.indexes {
+		idx.rebuild(&db.data)
+	}
+}
+
+// updateIndexes feeds the ops of a committed Update to every index
+func (db *DB) updateIndexes(old, next *DBStructure, ops []walOp) {
+	for _, op := range ops {
+		for _, idx := range db.indexes {
+			idx.apply(old, next, op)
+		}
+	}
+}
+
+// chirpChange returns the versions of the chirp touched by op before
+// and after the update, nil when it didn't exist
+func chirpChange(old, next *DBStructure, op walOp) (before, after *types.Chirp) {
+	var id int
+	if err := json.Unmarshal(op.Key, &id); err != nil {
+		return nil, nil
+	}
+	if chirp, ok := old.Chirps[id]; ok {
+		before = &chirp
+	}
+	if chirp, ok := next.Chirps[id]; ok {
+		after = &chirp
+	}
+	return before, after
+}
+
+// replyIndex maps a chirp to the chirps replying to it. Deleted and
+// unpublished replies are kept so the thread stays reachable.
+type replyIndex struct {
+	children map[int]map[int]replyState
+}
+
+// replyState is what counting the replies of a thread needs to know
+// about a reply
+type replyState struct {
+	published bool
+	deleted   bool
+}
+
+func (idx *replyIndex) rebuild(data *DBStructure) {
+	idx.children = map[int]map[int]replyState{}
+	for _, chirp := range data.Chirps {
+		chirp := chirp
+		idx.update(nil, &chirp)
+	}
+}
+
+func (idx *replyIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table == "chirps" {
+		idx.update(chirpChange(old, next, op))
+	}
+}
+
+func (idx *replyIndex) update(old, new *types.Chirp) {
+	if old != nil && old.ParentID != 0 {
+		delete(idx.children[old.ParentID], old.Id)
+		if len(idx.children[old.ParentID]) == 0 {
+			delete(idx.children, old.ParentID)
+		}
+	}
+	if new != nil && new.ParentID != 0 {
+		if idx.children[new.ParentID] == nil {
+			idx.children[new.ParentID] = map[int]replyState{}
+		}
+		idx.children[new.ParentID][new.Id] = replyState{published: new.Published(), deleted: new.Deleted}
+	}
+}
+
+// hasChildren reports whether any chirp replies to id, drafts and
+// deleted replies included
+func (idx *replyIndex) hasChildren(id int) bool {
+	return len(idx.children[id]) > 0
+}
+
+// visible reports whether a reply is listed in its thread: it is
+// published, and live or a tombstone with visible replies of its own
+func (idx *replyIndex) visible(id int, state replyState) bool {
+	return state.published && (!state.deleted || idx.hasReplies(id))
+}
+
+// hasReplies reports whether id has visible replies. Deleted chirps
+// with visible replies show as tombstones in their thread.
+func (idx *replyIndex) hasReplies(id int) bool {
+	for replyID, state := range idx.children[id] {
+		if idx.visible(replyID, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// threadReplies counts the visible replies of id
+func (idx *replyIndex) threadReplies(id int) int {
+	n := 0
+	for replyID, state := range idx.children[id] {
+		if idx.visible(replyID, state) {
+			n++
+		}
+	}
+	return n
+}
+
+// reactionIndex groups the reactions by chirp so counting them and
+// dropping them with their chirp doesn't scan every reaction
+type reactionIndex struct {
+	// keys holds the keys in DBStructure.Reactions of each chirp
+	keys map[int]map[string]bool
+	// counts holds the number of reactions of each kind of each chirp
+	counts map[int]map[string]int
+}
+
+func (idx *reactionIndex) rebuild(data *DBStructure) {
+	idx.keys = map[int]map[string]bool{}
+	idx.counts = map[int]map[string]int{}
+	for key, reaction := range data.Reactions {
+		idx.add(key, reaction)
+	}
+}
+
+func (idx *reactionIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table != "reactions" {
+		return
+	}
+	var key string
+	if err := json.Unmarshal(op.Key, &key); err != nil {
+		return
+	}
+	if reaction, ok := old.Reactions[key]; ok {
+		idx.remove(key, reaction)
+	}
+	if reaction, ok := next.Reactions[key]; ok {
+		idx.add(key, reaction)
+	}
+}
+
+func (idx *reactionIndex) add(key string, reaction types.Reaction) {
+	if idx.keys[reaction.ChirpID] == nil {
+		idx.keys[reaction.ChirpID] = map[string]bool{}
+		idx.counts[reaction.ChirpID] = map[string]int{}
+	}
+	idx.keys[reaction.ChirpID][key] = true
+	idx.counts[reaction.ChirpID][reaction.Kind]++
+}
+
+func (idx *reactionIndex) remove(key string, reaction types.Reaction) {
+	delete(idx.keys[reaction.ChirpID], key)
+	idx.counts[reaction.ChirpID][reaction.Kind]--
+	if len(idx.keys[reaction.ChirpID]) == 0 {
+		delete(idx.keys, reaction.ChirpID)
+		delete(idx.counts, reaction.ChirpID)
+	}
+}
+
+// followIndex holds the follow graph in both directions
+type followIndex struct {
+	// following maps a user to the users they follow
+	following map[int]map[int]bool
+	// followers maps a user to the users following them
+	followers map[int]map[int]bool
+}
+
+func (idx *followIndex) rebuild(data *DBStructure) {
+	idx.following = map[int]map[int]bool{}
+	idx.followers = map[int]map[int]bool{}
+	for _, follow := range data.Follows {
+		idx.add(follow)
+	}
+}
+
+func (idx *followIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table != "follows" {
+		return
+	}
+	var key string
+	if err := json.Unmarshal(op.Key, &key); err != nil {
+		return
+	}
+	if follow, ok := old.Follows[key]; ok {
+		idx.remove(follow)
+	}
+	if follow, ok := next.Follows[key]; ok {
+		idx.add(follow)
+	}
+}
+
+func (idx *followIndex) add(follow types.Follow) {
+	addEdge(idx.following, follow.FollowerID, follow.FolloweeID)
+	addEdge(idx.followers, follow.FolloweeID, follow.FollowerID)
+}
+
+func (idx *followIndex) remove(follow types.Follow) {
+	removeEdge(idx.following, follow.FollowerID, follow.FolloweeID)
+	removeEdge(idx.followers, follow.FolloweeID, follow.FollowerID)
+}
+
+func addEdge(edges map[int]map[int]bool, from, to int) {
+	if edges[from] == nil {
+		edges[from] = map[int]bool{}
+	}
+	edges[from][to] = true
+}
+
+func removeEdge(edges map[int]map[int]bool, from, to int) {
+	delete(edges[from], to)
+	if len(edges[from]) == 0 {
+		delete(edges, from)
+	}
+}
+
+// notificationIndex finds the notifications of a user, and the ones
+// about a chirp so they go away with it
+type notificationIndex struct {
+	byUser  map[int]map[int]bool
+	byChirp map[int]map[int]bool
+}
+
+func (idx *notificationIndex) rebuild(data *DBStructure) {
+	idx.byUser = map[int]map[int]bool{}
+	idx.byChirp = map[int]map[int]bool{}
+	for _, notification := range data.Notifications {
+		addEdge(idx.byUser, notification.UserID, notification.ID)
+		addEdge(idx.byChirp, notification.ChirpID, notification.ID)
+	}
+}
+
+func (idx *notificationIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table != "notifications" {
+		return
+	}
+	var id int
+	if err := json.Unmarshal(op.Key, &id); err != nil {
+		return
+	}
+	if notification, ok := old.Notifications[id]; ok {
+		removeEdge(idx.byUser, notification.UserID, id)
+		removeEdge(idx.byChirp, notification.ChirpID, id)
+	}
+	if notification, ok := next.Notifications[id]; ok {
+		addEdge(idx.byUser, notification.UserID, id)
+		addEdge(idx.byChirp, notification.ChirpID, id)
+	}
+}
+
+// bookmarkIndex finds the bookmarks of a chirp so they go away with it
+type bookmarkIndex struct {
+	// byChirp maps a chirp to the users who bookmarked it
+	byChirp map[int]map[int]bool
+}
+
+func (idx *bookmarkIndex) rebuild(data *DBStructure) {
+	idx.byChirp = map[int]map[int]bool{}
+	for _, bookmark := range data.Bookmarks {
+		addEdge(idx.byChirp, bookmark.ChirpID, bookmark.UserID)
+	}
+}
+
+func (idx *bookmarkIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table != "bookmarks" {
+		return
+	}
+	var key string
+	if err := json.Unmarshal(op.Key, &key); err != nil {
+		return
+	}
+	if bookmark, ok := old.Bookmarks[key]; ok {
+		removeEdge(idx.byChirp, bookmark.ChirpID, bookmark.UserID)
+	}
+	if bookmark, ok := next.Bookmarks[key]; ok {
+		addEdge(idx.byChirp, bookmark.ChirpID, bookmark.UserID)
+	}
+}
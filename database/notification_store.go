@@ -0,0 +1,50 @@
+package database
+
+import (
+	"slices"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// notifyMentions notifies the users mentioned in chirp, except its
+// author and the users in alreadyNotified, so editing a chirp only
+// notifies the newly mentioned users
+func notifyMentions(dbStructure *DBStructure, chirp types.Chirp, alreadyNotified []int) {
+	for _, userID := range chirp.Mentions {
+		if userID == chirp.AuthorID || slices.Contains(alreadyNotified, userID) {
+			continue
+		}
+		newID := nextID(dbStructure, notificationsTable)
+		notificationsTable.put(dbStructure, newID, types.Notification{
+			ID:        newID,
+			UserID:    userID,
+			Type:      types.NotificationMention,
+			ActorID:   chirp.AuthorID,
+			ChirpID:   chirp.Id,
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+}
+
+// GetNotifications returns the notifications of userID, newest first
+func (db *DB) GetNotifications(userID int) ([]types.Notification, error) {
+	notifications := []types.Notification{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for id := range db.notifications.byUser[userID] {
+			notification := dbStructure.Notifications[id]
+			// Notifications about deleted chirps come back if they are
+			// restored
+			if dbStructure.Chirps[notification.ChirpID].Deleted {
+				continue
+			}
+			notifications = append(notifications, notification)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(notifications, func(a, b types.Notification) int { return b.ID - a.ID })
+	return notifications, nil
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultBackups is how many backups NewDB keeps around
+	DefaultBackups = 5
+	// DefaultBackupInterval is the minimum time between two backups
+	DefaultBackupInterval = 10 * time.Minute
+	// DefaultCompactEvery is how many updates the write-ahead log holds
+	// before it is folded into a new snapshot
+	DefaultCompactEvery = 500
+
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Options tunes how the JSON database is persisted
+type Options struct {
+	// Backups is the number of timestamped copies of the database file
+	// to keep. Zero disables backups.
+	Backups int
+	// BackupInterval is the minimum time between two backups, so a busy
+	// server doesn't rotate out every useful copy within seconds.
+	BackupInterval time.Duration
+	// CompactEvery is the number of write-ahead log records after which
+	// the snapshot file is rewritten. Zero only compacts on ResetDB.
+	CompactEvery int
+}
+
+// atomicWriteFile writes data to a temp file in the same directory,
+// fsyncs it and renames it over path, so readers see either the old or
+// the new content but never a truncated file
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename durable by flushing the directory entry
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	err = d.Sync()
+	if err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// removeTempFiles cleans up temp files left behind by a crash in the
+// middle of atomicWriteFile
+func (db *DB) removeTempFiles() {
+	matches, _ := filepath.Glob(db.path + ".tmp-*")
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// backupPaths returns the existing backups, newest first
+func (db *DB) backupPaths() []string {
+	matches, _ := filepath.Glob(db.path + ".bak-*")
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches
+}
+
+// backup keeps a copy of the current database file before it gets
+// replaced and prunes the oldest copies. Callers must hold db.mux.
+func (db *DB) backup() error {
+	if db.opts.Backups <= 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	if now.Sub(db.lastBackup) < db.opts.BackupInterval {
+		return nil
+	}
+
+	dst := db.path + ".bak-" + now.Format(backupTimeFormat)
+	// The primary file is about to be replaced by a rename, so a hard
+	// link is enough to keep the old content around
+	if err := os.Link(db.path, dst); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err := copyFile(db.path, dst); err != nil {
+			return fmt.Errorf("failed to back up database: %v", err)
+		}
+	}
+	db.lastBackup = now
+
+	backups := db.backupPaths()
+	for len(backups) > db.opts.Backups {
+		os.Remove(backups[len(backups)-1])
+		backups = backups[:len(backups)-1]
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// recoverDB replaces a corrupt database file with the newest backup
+// that can still be parsed. The write-ahead log was written against the
+// lost snapshot, not the backup, so it is moved aside with the broken
+// file instead of being replayed.
+func (db *DB) recoverDB(cause error) error {
+	for _, candidate := range db.backupPaths() {
+		dat, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		var dbStructure DBStructure
+		if err := json.Unmarshal(dat, &dbStructure); err != nil {
+			continue
+		}
+		corrupt := db.path + ".corrupt-" + time.Now().UTC().Format(backupTimeFormat)
+		if err := os.Rename(db.path, corrupt); err != nil {
+			return err
+		}
+		records, err := countRecords(db.walPath())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			if err := os.Rename(db.walPath(), corrupt+".wal"); err != nil {
+				return err
+			}
+		}
+		if err := atomicWriteFile(db.path, dat, 0600); err != nil {
+			return err
+		}
+		log.Printf("database %s is corrupt (%v), restored %s and kept the broken file as %s",
+			db.path, cause, filepath.Base(candidate), filepath.Base(corrupt))
+		if records > 0 {
+			log.Printf("discarded the %d updates of the write-ahead log made since the lost snapshot, kept as %s",
+				records, filepath.Base(corrupt+".wal"))
+		}
+		return nil
+	}
+	return fmt.Errorf("database %s is corrupt and no valid backup was found: %w", db.path, cause)
+}
+
+// countRecords returns the number of records in the write-ahead log at
+// path
+func countRecords(path string) (int, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(dat, []byte{'\n'}), nil
+}
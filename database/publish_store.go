@@ -0,0 +1,59 @@
+package database
+
+import (
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// publish makes chirp visible as if it was created at now and notifies
+// the users it mentions
+func publish(dbStructure *DBStructure, chirp types.Chirp, now time.Time) types.Chirp {
+	chirp.Draft = false
+	chirp.PublishAt = nil
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	chirpsTable.put(dbStructure, chirp.Id, chirp)
+	notifyMentions(dbStructure, chirp, nil)
+	return chirp
+}
+
+// PublishChirp publishes a draft or scheduled chirp right away.
+// Publishing a published chirp does nothing.
+func (db *DB) PublishChirp(id int) (types.Chirp, error) {
+	var published types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.Deleted {
+			return ErrNotExist
+		}
+		published = chirp
+		if !chirp.Published() {
+			published = publish(dbStructure, chirp, time.Now().UTC())
+		}
+		return nil
+	})
+	if err != nil {
+		return types.Chirp{}, err
+	}
+	return published, nil
+}
+
+// PublishDueChirps publishes the scheduled chirps whose time has come
+// and returns them
+func (db *DB) PublishDueChirps(now time.Time) ([]types.Chirp, error) {
+	var published []types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.Deleted || chirp.Draft || chirp.PublishAt == nil || chirp.PublishAt.After(now) {
+				continue
+			}
+			published = append(published, publish(dbStructure, chirp, now.UTC()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return published, nil
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// ChirpStats are the counters shown next to a chirp. Liked, Reposted
+// and Bookmarked tell whether the viewer passed to GetChirpStats reacted
+// to it or saved it.
+type ChirpStats struct {
+	Replies    int
+	Likes      int
+	Reposts    int
+	Liked      bool
+	Reposted   bool
+	Bookmarked bool
+}
+
+func reactionKey(kind string, chirpID, userID int) string {
+	return fmt.Sprintf("%s:%d:%d", kind, chirpID, userID)
+}
+
+// AddReaction records that userID liked or reposted a chirp. Reacting
+// twice is not an error.
+func (db *DB) AddReaction(kind string, chirpID, userID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !chirp.Published() {
+			return ErrNotExist
+		}
+		key := reactionKey(kind, chirpID, userID)
+		if _, ok := dbStructure.Reactions[key]; ok {
+			return nil
+		}
+		reactionsTable.put(dbStructure, key, types.Reaction{
+			Kind:      kind,
+			ChirpID:   chirpID,
+			UserID:    userID,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+// RemoveReaction undoes AddReaction. Removing a reaction that doesn't
+// exist is not an error.
+func (db *DB) RemoveReaction(kind string, chirpID, userID int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return ErrNotExist
+		}
+		reactionsTable.del(dbStructure, reactionKey(kind, chirpID, userID))
+		return nil
+	})
+}
+
+// GetChirpStats returns the stats of each chirp in ids as seen by
+// viewerID, 0 for anonymous requests
+func (db *DB) GetChirpStats(ids []int, viewerID int) (map[int]ChirpStats, error) {
+	stats := make(map[int]ChirpStats, len(ids))
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, id := range ids {
+			counts := db.reactions.counts[id]
+			_, liked := dbStructure.Reactions[reactionKey(types.ReactionLike, id, viewerID)]
+			_, reposted := dbStructure.Reactions[reactionKey(types.ReactionRepost, id, viewerID)]
+			_, bookmarked := dbStructure.Bookmarks[bookmarkKey(viewerID, id)]
+			stats[id] = ChirpStats{
+				Replies:    db.replies.threadReplies(id),
+				Likes:      counts[types.ReactionLike],
+				Reposts:    counts[types.ReactionRepost],
+				Liked:      viewerID != 0 && liked,
+				Reposted:   viewerID != 0 && reposted,
+				Bookmarked: viewerID != 0 && bookmarked,
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
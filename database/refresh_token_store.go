@@ -1,7 +1,10 @@
 package database
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/erwaen/Chirpy/types"
@@ -9,21 +12,38 @@ import (
 
 var ErrTokenExpired = errors.New("Token Expired")
 
-func (db *DB) InsertRefreshToken(userID int, refreshToken string, expiresAt time.Duration) (types.RefreshToken, error) {
+// ErrTokenReused is returned when a refresh token that was already
+// rotated is used again. Its whole family is revoked, as either the
+// client or whoever stole the token has a newer one.
+var ErrTokenReused = errors.New("Token Reused")
 
-	expireTime := time.Now().Add(expiresAt)
+// hashRefreshToken is the key a refresh token is stored under
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertRefreshToken stores a refresh token issued at login, starting a
+// new family
+func (db *DB) InsertRefreshToken(userID int, refreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error) {
+	hash := hashRefreshToken(refreshToken)
+	now := time.Now().UTC()
 	newRefreshTokenStruct := types.RefreshToken{
-		RefreshToken: refreshToken,
-		UserID:       userID,
-		ExpireAt:     expireTime,
+		TokenHash:  hash,
+		UserID:     userID,
+		FamilyID:   hash,
+		ExpireAt:   now.Add(expiresAt),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
 	}
 
-	dat, err := db.loadDB()
-	if err != nil {
-		return types.RefreshToken{}, err
-	}
-	dat.RefreshTokens[refreshToken] = newRefreshTokenStruct
-	err = db.writeDB(dat)
+	err := db.Update(func(dbStructure *DBStructure) error {
+		pruneRefreshTokens(dbStructure, time.Now())
+		refreshTokensTable.put(dbStructure, hash, newRefreshTokenStruct)
+		return nil
+	})
 	if err != nil {
 		return types.RefreshToken{}, err
 	}
@@ -31,35 +51,172 @@ func (db *DB) InsertRefreshToken(userID int, refreshToken string, expiresAt time
 }
 
 func (db *DB) GetRefreshTokenStruct(refreshToken string) (types.RefreshToken, error) {
-	dat, err := db.loadDB()
+	var rf types.RefreshToken
+	err := db.View(func(dbStructure *DBStructure) error {
+		var exists bool
+		rf, exists = dbStructure.RefreshTokens[hashRefreshToken(refreshToken)]
+		if !exists {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return types.RefreshToken{}, err
 	}
+	return rf, nil
+}
+
+// RotateRefreshToken exchanges refreshToken for newRefreshToken in the
+// same family. The old token stays stored as rotated until it expires
+// so using it again is detected: that revokes the family and returns
+// ErrTokenReused.
+func (db *DB) RotateRefreshToken(refreshToken, newRefreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error) {
+	var rotated types.RefreshToken
+	var reused bool
+	err := db.Update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		rf, exists := dbStructure.RefreshTokens[hashRefreshToken(refreshToken)]
+		if !exists {
+			return ErrNotExist
+		}
+		if rf.RotatedAt != nil {
+			// The revocation has to be committed, so the error is
+			// only returned once the Update is done
+			reused = true
+			revokeRefreshTokenFamily(dbStructure, rf.FamilyID)
+			return nil
+		}
+		if now.After(rf.ExpireAt) {
+			return ErrTokenExpired
+		}
 
-	rf, exists := dat.RefreshTokens[refreshToken]
-	if !exists {
-		return types.RefreshToken{}, ErrNotExist
+		pruneRefreshTokens(dbStructure, now)
+		rf.RotatedAt = &now
+		refreshTokensTable.put(dbStructure, rf.TokenHash, rf)
+		rotated = types.RefreshToken{
+			TokenHash:  hashRefreshToken(newRefreshToken),
+			UserID:     rf.UserID,
+			FamilyID:   rf.FamilyID,
+			ExpireAt:   now.Add(expiresAt),
+			CreatedAt:  rf.CreatedAt,
+			LastUsedAt: now,
+			UserAgent:  userAgent,
+			IP:         ip,
+		}
+		refreshTokensTable.put(dbStructure, rotated.TokenHash, rotated)
+		return nil
+	})
+	if err != nil {
+		return types.RefreshToken{}, err
 	}
-	return rf, nil
+	if reused {
+		return types.RefreshToken{}, ErrTokenReused
+	}
+	return rotated, nil
 }
 
+// RevokeRefreshToken revokes refreshToken along with the rest of its
+// family
 func (db *DB) RevokeRefreshToken(refreshToken string) (types.RefreshToken, error) {
-	dat, err := db.loadDB()
+	var deleteElement types.RefreshToken
+	err := db.Update(func(dbStructure *DBStructure) error {
+		rf, exists := dbStructure.RefreshTokens[hashRefreshToken(refreshToken)]
+		if !exists {
+			return ErrNotExist
+		}
+		deleteElement = rf
+		revokeRefreshTokenFamily(dbStructure, rf.FamilyID)
+		return nil
+	})
 	if err != nil {
 		return types.RefreshToken{}, err
 	}
+	return deleteElement, nil
+}
 
-	deleteElement, exists := dat.RefreshTokens[refreshToken]
-	if !exists {
-		return types.RefreshToken{}, ErrNotExist
+// GetSessions returns the current token of each family of userID, the
+// most recently used first
+func (db *DB) GetSessions(userID int) ([]types.RefreshToken, error) {
+	var sessions []types.RefreshToken
+	err := db.View(func(dbStructure *DBStructure) error {
+		now := time.Now()
+		for _, rf := range dbStructure.RefreshTokens {
+			if rf.UserID == userID && rf.RotatedAt == nil && rf.TokenHash != "" && !now.After(rf.ExpireAt) {
+				sessions = append(sessions, rf)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	sortSessions(sessions)
+	return sessions, nil
+}
 
-	delete(dat.RefreshTokens, refreshToken)
+// RevokeSession revokes the family familyID of userID
+func (db *DB) RevokeSession(userID int, familyID string) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		for _, rf := range dbStructure.RefreshTokens {
+			if rf.FamilyID == familyID && rf.UserID == userID {
+				revokeRefreshTokenFamily(dbStructure, familyID)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+}
 
-	err = db.writeDB(dat)
+// RevokeAllSessions revokes every refresh token of userID and bumps
+// their token version so the access tokens already issued stop working
+// too
+func (db *DB) RevokeAllSessions(userID int) (types.User, error) {
+	var user types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.TokenVersion++
+		usersTable.put(dbStructure, user.Id, user)
+		for hash, rf := range dbStructure.RefreshTokens {
+			if rf.UserID == userID {
+				refreshTokensTable.del(dbStructure, hash)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return types.RefreshToken{}, err
+		return types.User{}, err
+	}
+	return user, nil
+}
+
+// sortSessions orders sessions the most recently used first
+func sortSessions(sessions []types.RefreshToken) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].FamilyID < sessions[j].FamilyID
+	})
+}
+
+func revokeRefreshTokenFamily(dbStructure *DBStructure, familyID string) {
+	for hash, rf := range dbStructure.RefreshTokens {
+		if rf.FamilyID == familyID {
+			refreshTokensTable.del(dbStructure, hash)
+		}
 	}
+}
 
-	return deleteElement, err
+// pruneRefreshTokens drops the tokens that expired, including the ones
+// stored in plain text before tokens were hashed
+func pruneRefreshTokens(dbStructure *DBStructure, now time.Time) {
+	for hash, rf := range dbStructure.RefreshTokens {
+		if now.After(rf.ExpireAt) || rf.TokenHash == "" {
+			refreshTokensTable.del(dbStructure, hash)
+		}
+	}
 }
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+var (
+	ErrAlreadyReported = errors.New("chirp already reported")
+	ErrReportResolved  = errors.New("report already resolved")
+)
+
+// CreateReport files a report about a visible chirp. A user can't have
+// two open reports about the same chirp.
+func (db *DB) CreateReport(chirpID, reporterID int, reason string) (types.Report, error) {
+	var report types.Report
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !chirp.Published() {
+			return ErrNotExist
+		}
+		for _, r := range dbStructure.Reports {
+			if r.ChirpID == chirpID && r.ReporterID == reporterID && r.Status == types.ReportOpen {
+				return ErrAlreadyReported
+			}
+		}
+		newID := nextID(dbStructure, reportsTable)
+		report = types.Report{
+			ID:         newID,
+			ChirpID:    chirpID,
+			ReporterID: reporterID,
+			Reason:     reason,
+			Status:     types.ReportOpen,
+			CreatedAt:  time.Now().UTC(),
+		}
+		reportsTable.put(dbStructure, newID, report)
+		return nil
+	})
+	if err != nil {
+		return types.Report{}, err
+	}
+	return report, nil
+}
+
+func (db *DB) GetReport(id int) (types.Report, error) {
+	var report types.Report
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		report, ok = dbStructure.Reports[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
+	if err != nil {
+		return types.Report{}, err
+	}
+	return report, nil
+}
+
+// ListReports returns the reports with status, oldest first, or every
+// report when status is ""
+func (db *DB) ListReports(status string) ([]types.Report, error) {
+	reports := []types.Report{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, report := range dbStructure.Reports {
+			if status == "" || report.Status == status {
+				reports = append(reports, report)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(reports, func(a, b types.Report) int { return a.ID - b.ID })
+	return reports, nil
+}
+
+// ResolveReport closes an open report with status, along with the other
+// open reports about the same chirp since they are handled by the same
+// decision
+func (db *DB) ResolveReport(id int, status string) (types.Report, error) {
+	var resolved types.Report
+	err := db.Update(func(dbStructure *DBStructure) error {
+		report, ok := dbStructure.Reports[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if report.Status != types.ReportOpen {
+			return ErrReportResolved
+		}
+		now := time.Now().UTC()
+		for reportID, r := range dbStructure.Reports {
+			if r.ChirpID != report.ChirpID || r.Status != types.ReportOpen {
+				continue
+			}
+			r.Status = status
+			r.ResolvedAt = &now
+			reportsTable.put(dbStructure, reportID, r)
+		}
+		resolved = dbStructure.Reports[id]
+		return nil
+	})
+	if err != nil {
+		return types.Report{}, err
+	}
+	return resolved, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// RestoreChirp makes a deleted chirp visible again, as long as it
+// hasn't been purged
+func (db *DB) RestoreChirp(id int) (types.Chirp, error) {
+	var restored types.Chirp
+	err := db.Update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || !chirp.Restorable() {
+			return ErrNotExist
+		}
+		chirp.Deleted = false
+		chirp.DeletedAt = nil
+		chirp.DeletedBy = 0
+		chirpsTable.put(dbStructure, id, chirp)
+		restored = chirp
+		return nil
+	})
+	if err != nil {
+		return types.Chirp{}, err
+	}
+	return restored, nil
+}
+
+// PurgeDeletedChirps permanently removes the chirps deleted before
+// before, with their history, reactions, bookmarks, notifications and
+// reports,
+// and returns them as they were so their attachments can be removed
+// too. A purged chirp that has replies is kept as a tombstone so the
+// thread stays reachable; tombstones are removed once their last reply
+// is gone.
+func (db *DB) PurgeDeletedChirps(before time.Time) ([]types.Chirp, error) {
+	var ids []int
+	err := db.View(func(dbStructure *DBStructure) error {
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.Restorable() && chirp.DeletedAt.Before(before) {
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []types.Chirp
+	for _, id := range ids {
+		// One update per chirp so the reply index is up to date when the
+		// next one checks for replies
+		err := db.Update(func(dbStructure *DBStructure) error {
+			// The chirp may have been restored, or deleted again, since
+			chirp, ok := dbStructure.Chirps[id]
+			if !ok || !chirp.Restorable() || !chirp.DeletedAt.Before(before) {
+				return nil
+			}
+			purged = append(purged, chirp)
+			db.purge(dbStructure, chirp)
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purge removes chirp and what belongs to it from dbStructure. The
+// indexes still reflect the state before the update.
+func (db *DB) purge(dbStructure *DBStructure, chirp types.Chirp) {
+	id := chirp.Id
+	chirpHistoryTable.del(dbStructure, id)
+	for key := range db.reactions.keys[id] {
+		reactionsTable.del(dbStructure, key)
+	}
+	for notificationID := range db.notifications.byChirp[id] {
+		notificationsTable.del(dbStructure, notificationID)
+	}
+	for userID := range db.bookmarks.byChirp[id] {
+		bookmarksTable.del(dbStructure, bookmarkKey(userID, id))
+	}
+	for reportID, report := range dbStructure.Reports {
+		if report.ChirpID == id {
+			reportsTable.del(dbStructure, reportID)
+		}
+	}
+
+	if db.replies.hasChildren(id) {
+		chirp.Body = ""
+		chirp.Hashtags = nil
+		chirp.Mentions = nil
+		chirp.Attachments = nil
+		chirp.DeletedAt = nil
+		chirp.UpdatedAt = time.Now().UTC()
+		chirpsTable.put(dbStructure, id, chirp)
+		return
+	}
+
+	chirpsTable.del(dbStructure, id)
+	// The removed chirp is the only reply left of its parent in the index
+	for chirp.ParentID != 0 {
+		parent, ok := dbStructure.Chirps[chirp.ParentID]
+		if !ok || !parent.Deleted || parent.Restorable() || len(db.replies.children[parent.Id]) > 1 {
+			break
+		}
+		chirpsTable.del(dbStructure, parent.Id)
+		chirp = parent
+	}
+}
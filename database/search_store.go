@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/erwaen/Chirpy/search"
+	"github.com/erwaen/Chirpy/types"
+)
+
+var ErrEmptySearch = errors.New("Search query is empty")
+
+// SearchQuery is a full-text search over chirp bodies. Text holds words
+// and "quoted phrases", all of which must match.
+type SearchQuery struct {
+	Text string
+	// AuthorID limits the result to one author, 0 means every author
+	AuthorID int
+	// Limit caps the number of chirps returned, 0 means no limit
+	Limit int
+}
+
+// searchIndex keeps the bodies of the live, published chirps in a
+// search.Index
+type searchIndex struct {
+	index *search.Index
+}
+
+func (idx *searchIndex) rebuild(data *DBStructure) {
+	idx.index = search.NewIndex()
+	for _, chirp := range data.Chirps {
+		if !chirp.Deleted && chirp.Published() {
+			idx.index.Add(chirp.Id, chirp.Body)
+		}
+	}
+}
+
+func (idx *searchIndex) apply(old, next *DBStructure, op walOp) {
+	if op.Table != "chirps" {
+		return
+	}
+	before, after := chirpChange(old, next, op)
+	switch {
+	case after != nil && !after.Deleted && after.Published():
+		idx.index.Add(after.Id, after.Body)
+	case before != nil:
+		idx.index.Remove(before.Id)
+	}
+}
+
+// SearchChirps returns the chirps matching q, best match first
+func (db *DB) SearchChirps(q SearchQuery) ([]types.Chirp, error) {
+	query := search.ParseQuery(q.Text)
+	if query.Empty() {
+		return nil, ErrEmptySearch
+	}
+
+	chirps := []types.Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		hits := db.search.index.Search(query, func(id int) bool {
+			return q.AuthorID == 0 || dbStructure.Chirps[id].AuthorID == q.AuthorID
+		})
+		if q.Limit > 0 && len(hits) > q.Limit {
+			hits = hits[:q.Limit]
+		}
+		for _, hit := range hits {
+			chirps = append(chirps, dbStructure.Chirps[hit.ID])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
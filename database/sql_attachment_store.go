@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// AddAttachment appends attachment to a chirp
+func (s *SQLDB) AddAttachment(chirpID int, attachment types.Attachment) (types.Chirp, error) {
+	var updated types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", chirpID))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && chirp.Deleted {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if len(chirp.Attachments) >= MaxAttachments {
+			return ErrTooManyAttachments
+		}
+		chirp.Attachments = append(chirp.Attachments, attachment)
+		_, err = tx.Exec("UPDATE chirpy_chirps SET attachments = ? WHERE id = ?", toListColumn(chirp.Attachments), chirpID)
+		updated = chirp
+		return err
+	})
+	if errors.Is(err, ErrNotExist) || errors.Is(err, ErrTooManyAttachments) {
+		return types.Chirp{}, err
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to add attachment: %v", err)
+	}
+	return updated, nil
+}
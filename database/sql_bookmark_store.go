@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddBookmark saves a chirp for userID. Bookmarking twice is not an
+// error.
+func (s *SQLDB) AddBookmark(userID, chirpID int) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		var visible bool
+		err := tx.QueryRow("SELECT deleted = 0 AND "+publishedSQL+" FROM chirpy_chirps WHERE id = ?", chirpID).Scan(&visible)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !visible {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO chirpy_bookmarks (user_id, chirp_id, created_at) VALUES (?, ?, ?)",
+			userID, chirpID, toMillis(time.Now()),
+		)
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add bookmark: %v", err)
+	}
+	return nil
+}
+
+// RemoveBookmark undoes AddBookmark. Removing a bookmark that doesn't
+// exist is not an error.
+func (s *SQLDB) RemoveBookmark(userID, chirpID int) error {
+	if _, err := s.GetChirp(chirpID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM chirpy_bookmarks WHERE user_id = ? AND chirp_id = ?", userID, chirpID)
+	if err != nil {
+		return fmt.Errorf("failed to remove bookmark: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,572 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// SQLDB stores chirps, users and refresh tokens in a libsql/SQLite
+// database. Table names are prefixed so they can live next to the shop
+// tables in the same Turso database.
+type SQLDB struct {
+	db *sql.DB
+}
+
+// sqlMigrations are applied in order, each exactly once. Append new
+// statements at the end and never edit the ones already released.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS chirpy_users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		email         TEXT NOT NULL UNIQUE,
+		password      TEXT NOT NULL,
+		is_chirpy_red INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS chirpy_chirps (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		body      TEXT NOT NULL,
+		author_id INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_chirps_author_idx ON chirpy_chirps (author_id)`,
+	`CREATE TABLE IF NOT EXISTS chirpy_refresh_tokens (
+		refresh_token TEXT PRIMARY KEY,
+		user_id       INTEGER NOT NULL,
+		expires_at    INTEGER NOT NULL
+	)`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS chirpy_chirp_versions (
+		chirp_id   INTEGER NOT NULL,
+		version    INTEGER NOT NULL,
+		body       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, version)
+	)`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN flagged INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS chirpy_chirps_parent_idx ON chirpy_chirps (parent_id)`,
+	`CREATE TABLE IF NOT EXISTS chirpy_reactions (
+		kind       TEXT NOT NULL,
+		chirp_id   INTEGER NOT NULL,
+		user_id    INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, kind, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS chirpy_follows (
+		follower_id INTEGER NOT NULL,
+		followee_id INTEGER NOT NULL,
+		created_at  INTEGER NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_follows_followee_idx ON chirpy_follows (followee_id)`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN hashtags TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN mentions TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS chirpy_chirp_hashtags (
+		tag      TEXT NOT NULL,
+		chirp_id INTEGER NOT NULL,
+		PRIMARY KEY (tag, chirp_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_chirp_hashtags_chirp_idx ON chirpy_chirp_hashtags (chirp_id)`,
+	`CREATE TABLE IF NOT EXISTS chirpy_notifications (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER NOT NULL,
+		type       TEXT NOT NULL,
+		actor_id   INTEGER NOT NULL,
+		chirp_id   INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_notifications_user_idx ON chirpy_notifications (user_id)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_notifications_chirp_idx ON chirpy_notifications (chirp_id)`,
+	// Full-text index over chirp bodies, kept in sync by triggers. The
+	// tokenizer matches search.Tokenize: lowercased letters and digits,
+	// diacritics kept.
+	`CREATE VIRTUAL TABLE IF NOT EXISTS chirpy_chirps_fts USING fts5(
+		body, content='chirpy_chirps', content_rowid='id',
+		tokenize='unicode61 remove_diacritics 0'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS chirpy_chirps_fts_insert AFTER INSERT ON chirpy_chirps BEGIN
+		INSERT INTO chirpy_chirps_fts (rowid, body) VALUES (new.id, new.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS chirpy_chirps_fts_delete AFTER DELETE ON chirpy_chirps BEGIN
+		INSERT INTO chirpy_chirps_fts (chirpy_chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS chirpy_chirps_fts_update AFTER UPDATE OF body ON chirpy_chirps BEGIN
+		INSERT INTO chirpy_chirps_fts (chirpy_chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+		INSERT INTO chirpy_chirps_fts (rowid, body) VALUES (new.id, new.body);
+	END`,
+	`INSERT INTO chirpy_chirps_fts (chirpy_chirps_fts) VALUES ('rebuild')`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN attachments TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN draft INTEGER NOT NULL DEFAULT 0`,
+	// publish_at is 0 once a chirp is published
+	`ALTER TABLE chirpy_chirps ADD COLUMN publish_at INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS chirpy_chirps_publish_at_idx ON chirpy_chirps (publish_at) WHERE publish_at > 0`,
+	`CREATE TABLE IF NOT EXISTS chirpy_bookmarks (
+		user_id    INTEGER NOT NULL,
+		chirp_id   INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_bookmarks_chirp_idx ON chirpy_bookmarks (chirp_id)`,
+	// deleted_at is 0 for visible chirps and for purged tombstones
+	`ALTER TABLE chirpy_chirps ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_chirps ADD COLUMN deleted_by INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS chirpy_chirps_deleted_at_idx ON chirpy_chirps (deleted_at) WHERE deleted_at > 0`,
+	`ALTER TABLE chirpy_users ADD COLUMN suspended INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS chirpy_reports (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		chirp_id    INTEGER NOT NULL,
+		reporter_id INTEGER NOT NULL,
+		reason      TEXT NOT NULL,
+		status      TEXT NOT NULL,
+		created_at  INTEGER NOT NULL,
+		resolved_at INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS chirpy_reports_status_idx ON chirpy_reports (status)`,
+	// Refresh tokens are stored hashed from here on. The plain text ones
+	// can't be hashed in SQL, so their users log in again.
+	`DELETE FROM chirpy_refresh_tokens`,
+	`ALTER TABLE chirpy_refresh_tokens RENAME COLUMN refresh_token TO token_hash`,
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT ''`,
+	// rotated_at is 0 for the current token of a family
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN rotated_at INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS chirpy_refresh_tokens_family_idx ON chirpy_refresh_tokens (family_id)`,
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN last_used_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chirpy_refresh_tokens ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS chirpy_refresh_tokens_user_idx ON chirpy_refresh_tokens (user_id)`,
+	`ALTER TABLE chirpy_users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE chirpy_users ADD COLUMN role TEXT NOT NULL DEFAULT 'user'`,
+}
+
+// publishedSQL is the condition for chirps visible to everyone
+const publishedSQL = "draft = 0 AND publish_at = 0"
+
+// threadSQL selects the chirps listed in a thread: live ones, and
+// deleted ones as tombstones when they have visible replies, that is
+// when a live reply can be reached through published replies
+const threadSQL = `(deleted = 0 OR EXISTS (
+	WITH RECURSIVE below(id, deleted) AS (
+		SELECT r.id, r.deleted FROM chirpy_chirps r
+		WHERE r.parent_id = chirpy_chirps.id AND r.draft = 0 AND r.publish_at = 0
+		UNION ALL
+		SELECT r.id, r.deleted FROM chirpy_chirps r JOIN below ON r.parent_id = below.id
+		WHERE below.deleted = 1 AND r.draft = 0 AND r.publish_at = 0
+	)
+	SELECT 1 FROM below WHERE deleted = 0))`
+
+// NewSQLDB wraps an open database connection and creates the tables
+// if they don't exist yet
+func NewSQLDB(db *sql.DB) (*SQLDB, error) {
+	s := &SQLDB{db: db}
+	err := s.migrate()
+	return s, err
+}
+
+// migrate applies the migrations the database hasn't seen yet. The
+// first ones are idempotent, so databases created before migrations
+// were tracked are picked up as well.
+func (s *SQLDB) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS chirpy_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+	var applied int
+	err = s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM chirpy_migrations").Scan(&applied)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := applied; i < len(sqlMigrations); i++ {
+		err := s.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqlMigrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO chirpy_migrations (version) VALUES (?)", i+1)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *SQLDB) inTx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetDB removes every row owned by the store
+func (s *SQLDB) ResetDB() error {
+	for _, table := range []string{"chirpy_refresh_tokens", "chirpy_reports", "chirpy_bookmarks", "chirpy_notifications", "chirpy_chirp_hashtags", "chirpy_reactions", "chirpy_follows", "chirpy_chirp_versions", "chirpy_chirps", "chirpy_users"} {
+		if _, err := s.db.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// toMillis and fromMillis convert timestamps to the INTEGER columns
+// used by the schema
+func toMillis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
+
+const chirpColumns = "id, body, author_id, created_at, updated_at, flagged, parent_id, deleted, hashtags, mentions, attachments, draft, publish_at, deleted_at, deleted_by"
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface{ Scan(...any) error }
+
+// extraColumns scans the columns selected after the chirp ones into dest
+type extraColumns struct {
+	scanner
+	dest []any
+}
+
+func (e extraColumns) Scan(dest ...any) error {
+	return e.scanner.Scan(append(dest, e.dest...)...)
+}
+
+func scanChirp(row scanner) (types.Chirp, error) {
+	var chirp types.Chirp
+	var createdAt, updatedAt int64
+	var hashtags, mentions, attachments string
+	var publishAt, deletedAt int64
+	err := row.Scan(
+		&chirp.Id, &chirp.Body, &chirp.AuthorID, &createdAt, &updatedAt, &chirp.Flagged, &chirp.ParentID, &chirp.Deleted,
+		&hashtags, &mentions, &attachments, &chirp.Draft, &publishAt, &deletedAt, &chirp.DeletedBy,
+	)
+	if err != nil {
+		return chirp, err
+	}
+	chirp.CreatedAt = fromMillis(createdAt)
+	chirp.UpdatedAt = fromMillis(updatedAt)
+	if publishAt != 0 {
+		t := fromMillis(publishAt)
+		chirp.PublishAt = &t
+	}
+	if deletedAt != 0 {
+		t := fromMillis(deletedAt)
+		chirp.DeletedAt = &t
+	}
+	if err := fromListColumn(hashtags, &chirp.Hashtags); err != nil {
+		return chirp, err
+	}
+	if err := fromListColumn(mentions, &chirp.Mentions); err != nil {
+		return chirp, err
+	}
+	err = fromListColumn(attachments, &chirp.Attachments)
+	return chirp, err
+}
+
+// toListColumn and fromListColumn store the small lists of a chirp as
+// JSON in a TEXT column, an empty column being an empty list
+func toListColumn[T any](list []T) string {
+	if len(list) == 0 {
+		return ""
+	}
+	dat, _ := json.Marshal(list)
+	return string(dat)
+}
+
+func publishAtColumn(publishAt *time.Time) int64 {
+	if publishAt == nil {
+		return 0
+	}
+	return toMillis(*publishAt)
+}
+
+func fromListColumn[T any](column string, list *[]T) error {
+	if column == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(column), list)
+}
+
+// GetChirps returns all chirps in the database
+func (s *SQLDB) GetChirps(authorID int, sortBy string) ([]types.Chirp, error) {
+	return s.ListChirps(ChirpQuery{AuthorID: authorID, Sort: sortBy})
+}
+
+// ListChirps returns the chirps matching q
+func (s *SQLDB) ListChirps(q ChirpQuery) ([]types.Chirp, error) {
+	q = q.normalize()
+	order, cmp := "ASC", ">"
+	if q.Sort == "desc" {
+		order, cmp = "DESC", "<"
+	}
+	where := []string{"deleted = 0"}
+	if q.ParentID != 0 {
+		where = []string{threadSQL}
+	}
+	if q.Deleted {
+		where = []string{"deleted_at > 0"}
+	} else if q.Unpublished {
+		where = append(where, "NOT ("+publishedSQL+")")
+	} else {
+		where = append(where, publishedSQL)
+	}
+	from, args := "chirpy_chirps", []any{}
+	if q.BookmarkedBy != 0 {
+		from += " JOIN (SELECT chirp_id, created_at AS bookmarked_at FROM chirpy_bookmarks WHERE user_id = ?) ON chirp_id = id"
+		args = append(args, q.BookmarkedBy)
+	}
+	if q.ParentID != 0 {
+		where = append(where, "parent_id = ?")
+		args = append(args, q.ParentID)
+	}
+	if q.AuthorID != 0 {
+		where = append(where, "author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.FollowedBy != 0 {
+		where = append(where, "author_id IN (SELECT followee_id FROM chirpy_follows WHERE follower_id = ?)")
+		args = append(args, q.FollowedBy)
+	}
+	if q.Hashtag != "" {
+		where = append(where, "id IN (SELECT chirp_id FROM chirpy_chirp_hashtags WHERE tag = ?)")
+		args = append(args, q.Hashtag)
+	}
+	if q.Flagged {
+		where = append(where, "flagged = 1")
+	}
+	orderBy := "id " + order
+	if q.OrderBy != OrderByID {
+		orderBy = q.OrderBy + " " + order + ", " + orderBy
+	}
+	if q.After.ID != 0 {
+		if q.OrderBy == OrderByID {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, q.After.ID)
+		} else {
+			where = append(where, "("+q.OrderBy+", id) "+cmp+" (?, ?)")
+			args = append(args, toMillis(q.After.Time), q.After.ID)
+		}
+	}
+
+	columns := chirpColumns
+	if q.BookmarkedBy != 0 {
+		columns += ", bookmarked_at"
+	}
+	query := "SELECT " + columns + " FROM " + from + " WHERE " + strings.Join(where, " AND ")
+	query += " ORDER BY " + orderBy
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var chirps []types.Chirp
+	for rows.Next() {
+		var bookmarkedAt int64
+		row := scanner(rows)
+		if q.BookmarkedBy != 0 {
+			row = extraColumns{rows, []any{&bookmarkedAt}}
+		}
+		chirp, err := scanChirp(row)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		if q.BookmarkedBy != 0 {
+			t := fromMillis(bookmarkedAt)
+			chirp.BookmarkedAt = &t
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return chirps, nil
+}
+
+// CreateChirp saves chirp to the database with a new ID and creation
+// time
+func (s *SQLDB) CreateChirp(chirp types.Chirp) (types.Chirp, error) {
+	now := fromMillis(toMillis(time.Now()))
+	err := s.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`INSERT INTO chirpy_chirps (body, author_id, created_at, updated_at, flagged, parent_id, hashtags, mentions, draft, publish_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			chirp.Body, chirp.AuthorID, toMillis(now), toMillis(now), chirp.Flagged, chirp.ParentID,
+			toListColumn(chirp.Hashtags), toListColumn(chirp.Mentions), chirp.Draft, publishAtColumn(chirp.PublishAt),
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		chirp.Id = int(id)
+		if err := setChirpHashtags(tx, chirp.Id, chirp.Hashtags); err != nil {
+			return err
+		}
+		if !chirp.Published() {
+			return nil
+		}
+		return notifyMentionsTx(tx, chirp, nil)
+	})
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to insert chirp: %v", err)
+	}
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	return chirp, nil
+}
+
+func (s *SQLDB) GetChirp(id int) (types.Chirp, error) {
+	row := s.db.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", id)
+	chirp, err := scanChirp(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Chirp{}, ErrNotExist
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("error scanning row: %v", err)
+	}
+	return chirp, nil
+}
+
+// DeleteChirp hides a chirp, keeping everything about it so it can be
+// restored until PurgeDeletedChirps removes it for good
+func (s *SQLDB) DeleteChirp(id, deletedBy int) (types.Chirp, error) {
+	var deletedChirp types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && chirp.Deleted {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		now := fromMillis(toMillis(time.Now()))
+		_, err = tx.Exec(
+			"UPDATE chirpy_chirps SET deleted = 1, deleted_at = ?, deleted_by = ? WHERE id = ?",
+			toMillis(now), deletedBy, id,
+		)
+		chirp.Deleted = true
+		chirp.DeletedAt = &now
+		chirp.DeletedBy = deletedBy
+		deletedChirp = chirp
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return types.Chirp{}, err
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to delete chirp: %v", err)
+	}
+	return deletedChirp, nil
+}
+
+// placeholders returns "?, ?, ..." for an IN clause of n values
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// UpdateChirp saves the editable fields of chirp and keeps the previous
+// body in its history when it changed
+func (s *SQLDB) UpdateChirp(chirp types.Chirp) (types.Chirp, error) {
+	var updated types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		stored, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", chirp.Id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if stored.Body != chirp.Body {
+			_, err = tx.Exec(
+				`INSERT INTO chirpy_chirp_versions (chirp_id, version, body, created_at)
+				SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ? FROM chirpy_chirp_versions WHERE chirp_id = ?`,
+				chirp.Id, stored.Body, toMillis(stored.UpdatedAt), chirp.Id,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		updated = stored
+		updated.Body = chirp.Body
+		updated.Flagged = chirp.Flagged
+		updated.Hashtags = chirp.Hashtags
+		updated.Mentions = chirp.Mentions
+		updated.UpdatedAt = fromMillis(toMillis(time.Now()))
+		_, err = tx.Exec(
+			"UPDATE chirpy_chirps SET body = ?, flagged = ?, hashtags = ?, mentions = ?, updated_at = ? WHERE id = ?",
+			updated.Body, updated.Flagged, toListColumn(updated.Hashtags), toListColumn(updated.Mentions),
+			toMillis(updated.UpdatedAt), chirp.Id,
+		)
+		if err != nil {
+			return err
+		}
+		if err := setChirpHashtags(tx, chirp.Id, updated.Hashtags); err != nil {
+			return err
+		}
+		if !updated.Published() {
+			return nil
+		}
+		return notifyMentionsTx(tx, updated, stored.Mentions)
+	})
+	if errors.Is(err, ErrNotExist) {
+		return types.Chirp{}, err
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to update chirp: %v", err)
+	}
+	return updated, nil
+}
+
+// GetChirpHistory returns the previous versions of a chirp, oldest first
+func (s *SQLDB) GetChirpHistory(id int) ([]types.ChirpVersion, error) {
+	if _, err := s.GetChirp(id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(
+		"SELECT chirp_id, version, body, created_at FROM chirpy_chirp_versions WHERE chirp_id = ? ORDER BY version",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var history []types.ChirpVersion
+	for rows.Next() {
+		var version types.ChirpVersion
+		var createdAt int64
+		if err := rows.Scan(&version.ChirpID, &version.Version, &version.Body, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		version.CreatedAt = fromMillis(createdAt)
+		history = append(history, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQLDB opens the SQL store on an in-memory database through the
+// libsql driver, which hands file: URLs to the local SQLite driver
+func newTestSQLDB(t testing.TB) *SQLDB {
+	t.Helper()
+	raw, err := sql.Open("libsql", "file::memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Every connection would get its own in-memory database
+	raw.SetMaxOpenConns(1)
+	t.Cleanup(func() { raw.Close() })
+	s, err := NewSQLDB(raw)
+	if err != nil {
+		t.Fatalf("NewSQLDB: %v", err)
+	}
+	return s
+}
+
+func TestSQLDeleteRestorePurge(t *testing.T) {
+	s := newTestSQLDB(t)
+	chirp, err := s.CreateChirp(types.Chirp{Body: "soon gone", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	listed := func(q ChirpQuery) []int {
+		t.Helper()
+		chirps, err := s.ListChirps(q)
+		if err != nil {
+			t.Fatalf("ListChirps: %v", err)
+		}
+		ids := []int{}
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.Id)
+		}
+		return ids
+	}
+
+	deleted, err := s.DeleteChirp(chirp.Id, 2)
+	if err != nil {
+		t.Fatalf("DeleteChirp: %v", err)
+	}
+	if !deleted.Restorable() || deleted.DeletedBy != 2 {
+		t.Errorf("deleted chirp = %+v, want restorable and deleted by 2", deleted)
+	}
+	if ids := listed(ChirpQuery{}); len(ids) != 0 {
+		t.Errorf("visible chirps = %v, want none", ids)
+	}
+	if ids := listed(ChirpQuery{Deleted: true, OrderBy: OrderByDeletedAt}); len(ids) != 1 || ids[0] != chirp.Id {
+		t.Errorf("deleted chirps = %v, want [%d]", ids, chirp.Id)
+	}
+
+	if _, err := s.RestoreChirp(chirp.Id); err != nil {
+		t.Fatalf("RestoreChirp: %v", err)
+	}
+	if ids := listed(ChirpQuery{}); len(ids) != 1 {
+		t.Errorf("visible chirps = %v, want the restored chirp", ids)
+	}
+
+	if _, err := s.DeleteChirp(chirp.Id, 2); err != nil {
+		t.Fatalf("DeleteChirp: %v", err)
+	}
+	purged, err := s.PurgeDeletedChirps(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeletedChirps: %v", err)
+	}
+	if len(purged) != 1 || purged[0].Id != chirp.Id {
+		t.Errorf("purged %+v, want the chirp", purged)
+	}
+	if _, err := s.GetChirp(chirp.Id); !errors.Is(err, ErrNotExist) {
+		t.Errorf("GetChirp after purge: got %v, want ErrNotExist", err)
+	}
+	if _, err := s.RestoreChirp(chirp.Id); !errors.Is(err, ErrNotExist) {
+		t.Errorf("RestoreChirp after purge: got %v, want ErrNotExist", err)
+	}
+}
+
+func TestSQLSessions(t *testing.T) {
+	s := newTestSQLDB(t)
+	user, err := s.CreateUser("walter@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for _, token := range []string{"laptop", "phone"} {
+		if _, err := s.InsertRefreshToken(user.Id, token, time.Hour, token, "127.0.0.1"); err != nil {
+			t.Fatalf("InsertRefreshToken: %v", err)
+		}
+	}
+	if _, err := s.RotateRefreshToken("phone", "phone-2", time.Hour, "phone", "10.0.0.1"); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	sessions, err := s.GetSessions(user.Id)
+	if err != nil {
+		t.Fatalf("GetSessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].IP != "10.0.0.1" {
+		t.Fatalf("got sessions %+v, want the rotated phone first and the laptop", sessions)
+	}
+	if err := s.RevokeSession(user.Id, sessions[1].FamilyID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := s.GetRefreshTokenStruct("laptop"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("laptop token after RevokeSession: got %v, want ErrNotExist", err)
+	}
+
+	revoked, err := s.RevokeAllSessions(user.Id)
+	if err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	if revoked.TokenVersion != user.TokenVersion+1 {
+		t.Errorf("token version = %d, want %d", revoked.TokenVersion, user.TokenVersion+1)
+	}
+	if sessions, err := s.GetSessions(user.Id); err != nil || len(sessions) != 0 {
+		t.Errorf("sessions after RevokeAllSessions = %+v, %v, want none", sessions, err)
+	}
+}
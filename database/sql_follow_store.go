@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// Follow makes followerID follow followeeID. Following twice is not an
+// error.
+func (s *SQLDB) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrSelfFollow
+	}
+	if _, err := s.GetUserByID(followeeID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		"INSERT OR IGNORE INTO chirpy_follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)",
+		followerID, followeeID, toMillis(time.Now()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to follow user: %v", err)
+	}
+	return nil
+}
+
+// Unfollow undoes Follow. Unfollowing a user that isn't followed is not
+// an error.
+func (s *SQLDB) Unfollow(followerID, followeeID int) error {
+	if _, err := s.GetUserByID(followeeID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM chirpy_follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	if err != nil {
+		return fmt.Errorf("failed to unfollow user: %v", err)
+	}
+	return nil
+}
+
+// GetFollowers returns the users following userID, ordered by ID
+func (s *SQLDB) GetFollowers(userID int) ([]types.User, error) {
+	return s.followUsers(userID, "SELECT follower_id FROM chirpy_follows WHERE followee_id = ?")
+}
+
+// GetFollowing returns the users userID follows, ordered by ID
+func (s *SQLDB) GetFollowing(userID int) ([]types.User, error) {
+	return s.followUsers(userID, "SELECT followee_id FROM chirpy_follows WHERE follower_id = ?")
+}
+
+func (s *SQLDB) followUsers(userID int, ids string) ([]types.User, error) {
+	if _, err := s.GetUserByID(userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT "+userColumns+" FROM chirpy_users WHERE id IN ("+ids+") ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	users := []types.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// setChirpHashtags replaces the rows used to look chirps up by hashtag
+func setChirpHashtags(tx *sql.Tx, chirpID int, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM chirpy_chirp_hashtags WHERE chirp_id = ?", chirpID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO chirpy_chirp_hashtags (tag, chirp_id) VALUES (?, ?)", tag, chirpID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrendingHashtags returns the hashtags used by the most chirps created
+// since the given time, at most limit of them
+func (s *SQLDB) TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error) {
+	rows, err := s.db.Query(
+		`SELECT h.tag, COUNT(*) AS n FROM chirpy_chirp_hashtags h
+		JOIN chirpy_chirps c ON c.id = h.chirp_id
+		WHERE c.created_at >= ? AND c.deleted = 0 AND c.draft = 0 AND c.publish_at = 0
+		GROUP BY h.tag ORDER BY n DESC, h.tag LIMIT ?`,
+		toMillis(since), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	counts := []HashtagCount{}
+	for rows.Next() {
+		var c HashtagCount
+		if err := rows.Scan(&c.Tag, &c.Count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// notifyMentionsTx notifies the users mentioned in chirp, except its
+// author and the users in alreadyNotified, so editing a chirp only
+// notifies the newly mentioned users
+func notifyMentionsTx(tx *sql.Tx, chirp types.Chirp, alreadyNotified []int) error {
+	for _, userID := range chirp.Mentions {
+		if userID == chirp.AuthorID || slices.Contains(alreadyNotified, userID) {
+			continue
+		}
+		_, err := tx.Exec(
+			"INSERT INTO chirpy_notifications (user_id, type, actor_id, chirp_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			userID, types.NotificationMention, chirp.AuthorID, chirp.Id, toMillis(time.Now()),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotifications returns the notifications of userID, newest first
+func (s *SQLDB) GetNotifications(userID int) ([]types.Notification, error) {
+	rows, err := s.db.Query(
+		`SELECT id, user_id, type, actor_id, chirp_id, created_at FROM chirpy_notifications
+		WHERE user_id = ? AND chirp_id NOT IN (SELECT id FROM chirpy_chirps WHERE deleted = 1)
+		ORDER BY id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := []types.Notification{}
+	for rows.Next() {
+		var n types.Notification
+		var createdAt int64
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.ChirpID, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		n.CreatedAt = fromMillis(createdAt)
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return notifications, nil
+}
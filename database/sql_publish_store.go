@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// publishTx makes chirp visible as if it was created at now and
+// notifies the users it mentions
+func publishTx(tx *sql.Tx, chirp types.Chirp, now time.Time) (types.Chirp, error) {
+	chirp.Draft = false
+	chirp.PublishAt = nil
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	_, err := tx.Exec(
+		"UPDATE chirpy_chirps SET draft = 0, publish_at = 0, created_at = ?, updated_at = ? WHERE id = ?",
+		toMillis(now), toMillis(now), chirp.Id,
+	)
+	if err != nil {
+		return types.Chirp{}, err
+	}
+	return chirp, notifyMentionsTx(tx, chirp, nil)
+}
+
+// PublishChirp publishes a draft or scheduled chirp right away.
+// Publishing a published chirp does nothing.
+func (s *SQLDB) PublishChirp(id int) (types.Chirp, error) {
+	var published types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && chirp.Deleted {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		published = chirp
+		if !chirp.Published() {
+			published, err = publishTx(tx, chirp, fromMillis(toMillis(time.Now())))
+		}
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return types.Chirp{}, err
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to publish chirp: %v", err)
+	}
+	return published, nil
+}
+
+// PublishDueChirps publishes the scheduled chirps whose time has come
+// and returns them
+func (s *SQLDB) PublishDueChirps(now time.Time) ([]types.Chirp, error) {
+	now = fromMillis(toMillis(now))
+	var published []types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(
+			"SELECT "+chirpColumns+" FROM chirpy_chirps WHERE deleted = 0 AND draft = 0 AND publish_at > 0 AND publish_at <= ?",
+			toMillis(now),
+		)
+		if err != nil {
+			return err
+		}
+		var due []types.Chirp
+		for rows.Next() {
+			chirp, err := scanChirp(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, chirp)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, chirp := range due {
+			chirp, err := publishTx(tx, chirp, now)
+			if err != nil {
+				return err
+			}
+			published = append(published, chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish scheduled chirps: %v", err)
+	}
+	return published, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// AddReaction records that userID liked or reposted a chirp. Reacting
+// twice is not an error.
+func (s *SQLDB) AddReaction(kind string, chirpID, userID int) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		var visible bool
+		err := tx.QueryRow("SELECT deleted = 0 AND "+publishedSQL+" FROM chirpy_chirps WHERE id = ?", chirpID).Scan(&visible)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !visible {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO chirpy_reactions (kind, chirp_id, user_id, created_at) VALUES (?, ?, ?, ?)",
+			kind, chirpID, userID, toMillis(time.Now()),
+		)
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add reaction: %v", err)
+	}
+	return nil
+}
+
+// RemoveReaction undoes AddReaction. Removing a reaction that doesn't
+// exist is not an error.
+func (s *SQLDB) RemoveReaction(kind string, chirpID, userID int) error {
+	if _, err := s.GetChirp(chirpID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		"DELETE FROM chirpy_reactions WHERE kind = ? AND chirp_id = ? AND user_id = ?",
+		kind, chirpID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove reaction: %v", err)
+	}
+	return nil
+}
+
+// GetChirpStats returns the stats of each chirp in ids as seen by
+// viewerID, 0 for anonymous requests
+func (s *SQLDB) GetChirpStats(ids []int, viewerID int) (map[int]ChirpStats, error) {
+	stats := make(map[int]ChirpStats, len(ids))
+	if len(ids) == 0 {
+		return stats, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		stats[id] = ChirpStats{}
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(
+		"SELECT parent_id, COUNT(*) FROM chirpy_chirps WHERE "+threadSQL+" AND "+publishedSQL+" AND parent_id IN ("+placeholders(len(ids))+") GROUP BY parent_id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		st := stats[id]
+		st.Replies = n
+		stats[id] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	rows, err = s.db.Query(
+		"SELECT chirp_id, kind, COUNT(*), MAX(user_id = ?) FROM chirpy_reactions WHERE chirp_id IN ("+placeholders(len(ids))+") GROUP BY chirp_id, kind",
+		append([]any{viewerID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, n int
+		var kind string
+		var mine bool
+		if err := rows.Scan(&id, &kind, &n, &mine); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		st := stats[id]
+		switch kind {
+		case types.ReactionLike:
+			st.Likes, st.Liked = n, mine && viewerID != 0
+		case types.ReactionRepost:
+			st.Reposts, st.Reposted = n, mine && viewerID != 0
+		}
+		stats[id] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	if viewerID == 0 {
+		return stats, nil
+	}
+
+	rows, err = s.db.Query(
+		"SELECT chirp_id FROM chirpy_bookmarks WHERE user_id = ? AND chirp_id IN ("+placeholders(len(ids))+")",
+		append([]any{viewerID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		st := stats[id]
+		st.Bookmarked = true
+		stats[id] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+const refreshTokenColumns = "token_hash, user_id, expires_at, family_id, rotated_at, created_at, last_used_at, user_agent, ip"
+
+func scanRefreshToken(row interface{ Scan(...any) error }) (types.RefreshToken, error) {
+	var rf types.RefreshToken
+	var expiresAt, rotatedAt, createdAt, lastUsedAt int64
+	err := row.Scan(&rf.TokenHash, &rf.UserID, &expiresAt, &rf.FamilyID, &rotatedAt, &createdAt, &lastUsedAt, &rf.UserAgent, &rf.IP)
+	if err != nil {
+		return rf, err
+	}
+	rf.ExpireAt = fromMillis(expiresAt)
+	rf.CreatedAt = fromMillis(createdAt)
+	rf.LastUsedAt = fromMillis(lastUsedAt)
+	if rotatedAt != 0 {
+		t := fromMillis(rotatedAt)
+		rf.RotatedAt = &t
+	}
+	return rf, nil
+}
+
+func insertRefreshTokenTx(tx *sql.Tx, rf types.RefreshToken) error {
+	_, err := tx.Exec(
+		"INSERT INTO chirpy_refresh_tokens (token_hash, user_id, expires_at, family_id, created_at, last_used_at, user_agent, ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		rf.TokenHash, rf.UserID, toMillis(rf.ExpireAt), rf.FamilyID, toMillis(rf.CreatedAt), toMillis(rf.LastUsedAt), rf.UserAgent, rf.IP,
+	)
+	return err
+}
+
+// InsertRefreshToken stores a refresh token issued at login, starting a
+// new family
+func (s *SQLDB) InsertRefreshToken(userID int, refreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error) {
+	hash := hashRefreshToken(refreshToken)
+	now := fromMillis(toMillis(time.Now()))
+	newRefreshTokenStruct := types.RefreshToken{
+		TokenHash:  hash,
+		UserID:     userID,
+		FamilyID:   hash,
+		ExpireAt:   now.Add(expiresAt),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
+	}
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := pruneRefreshTokensTx(tx, time.Now()); err != nil {
+			return err
+		}
+		return insertRefreshTokenTx(tx, newRefreshTokenStruct)
+	})
+	if err != nil {
+		return types.RefreshToken{}, fmt.Errorf("failed to insert refresh token: %v", err)
+	}
+	return newRefreshTokenStruct, nil
+}
+
+func (s *SQLDB) GetRefreshTokenStruct(refreshToken string) (types.RefreshToken, error) {
+	rf, err := scanRefreshToken(s.db.QueryRow(
+		"SELECT "+refreshTokenColumns+" FROM chirpy_refresh_tokens WHERE token_hash = ?",
+		hashRefreshToken(refreshToken),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.RefreshToken{}, ErrNotExist
+	}
+	if err != nil {
+		return types.RefreshToken{}, fmt.Errorf("error scanning row: %v", err)
+	}
+	return rf, nil
+}
+
+// RotateRefreshToken exchanges refreshToken for newRefreshToken in the
+// same family. The old token stays stored as rotated until it expires
+// so using it again is detected: that revokes the family and returns
+// ErrTokenReused.
+func (s *SQLDB) RotateRefreshToken(refreshToken, newRefreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error) {
+	var rotated types.RefreshToken
+	var reused bool
+	err := s.inTx(func(tx *sql.Tx) error {
+		now := fromMillis(toMillis(time.Now()))
+		rf, err := scanRefreshToken(tx.QueryRow(
+			"SELECT "+refreshTokenColumns+" FROM chirpy_refresh_tokens WHERE token_hash = ?",
+			hashRefreshToken(refreshToken),
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if rf.RotatedAt != nil {
+			// The revocation has to be committed, so the error is
+			// only returned after the transaction
+			reused = true
+			_, err := tx.Exec("DELETE FROM chirpy_refresh_tokens WHERE family_id = ?", rf.FamilyID)
+			return err
+		}
+		if now.After(rf.ExpireAt) {
+			return ErrTokenExpired
+		}
+
+		if err := pruneRefreshTokensTx(tx, now); err != nil {
+			return err
+		}
+		// Another rotation of the same token may have committed since
+		// the SELECT: only one of them can mark it rotated
+		result, err := tx.Exec(
+			"UPDATE chirpy_refresh_tokens SET rotated_at = ? WHERE token_hash = ? AND rotated_at = 0",
+			toMillis(now), rf.TokenHash,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			reused = true
+			_, err := tx.Exec("DELETE FROM chirpy_refresh_tokens WHERE family_id = ?", rf.FamilyID)
+			return err
+		}
+		rotated = types.RefreshToken{
+			TokenHash:  hashRefreshToken(newRefreshToken),
+			UserID:     rf.UserID,
+			FamilyID:   rf.FamilyID,
+			ExpireAt:   now.Add(expiresAt),
+			CreatedAt:  rf.CreatedAt,
+			LastUsedAt: now,
+			UserAgent:  userAgent,
+			IP:         ip,
+		}
+		return insertRefreshTokenTx(tx, rotated)
+	})
+	if errors.Is(err, ErrNotExist) || errors.Is(err, ErrTokenExpired) {
+		return types.RefreshToken{}, err
+	}
+	if err != nil {
+		return types.RefreshToken{}, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	if reused {
+		return types.RefreshToken{}, ErrTokenReused
+	}
+	return rotated, nil
+}
+
+// RevokeRefreshToken revokes refreshToken along with the rest of its
+// family
+func (s *SQLDB) RevokeRefreshToken(refreshToken string) (types.RefreshToken, error) {
+	rf, err := s.GetRefreshTokenStruct(refreshToken)
+	if err != nil {
+		return types.RefreshToken{}, err
+	}
+	_, err = s.db.Exec("DELETE FROM chirpy_refresh_tokens WHERE family_id = ?", rf.FamilyID)
+	if err != nil {
+		return types.RefreshToken{}, fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+	return rf, nil
+}
+
+// GetSessions returns the current token of each family of userID, the
+// most recently used first
+func (s *SQLDB) GetSessions(userID int) ([]types.RefreshToken, error) {
+	rows, err := s.db.Query(
+		"SELECT "+refreshTokenColumns+" FROM chirpy_refresh_tokens WHERE user_id = ? AND rotated_at = 0 AND expires_at >= ? ORDER BY last_used_at DESC, family_id",
+		userID, toMillis(time.Now()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	var sessions []types.RefreshToken
+	for rows.Next() {
+		rf, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		sessions = append(sessions, rf)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes the family familyID of userID
+func (s *SQLDB) RevokeSession(userID int, familyID string) error {
+	result, err := s.db.Exec("DELETE FROM chirpy_refresh_tokens WHERE family_id = ? AND user_id = ?", familyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// RevokeAllSessions revokes every refresh token of userID and bumps
+// their token version so the access tokens already issued stop working
+// too
+func (s *SQLDB) RevokeAllSessions(userID int) (types.User, error) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE chirpy_users SET token_version = token_version + 1 WHERE id = ?", userID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrNotExist
+		}
+		_, err = tx.Exec("DELETE FROM chirpy_refresh_tokens WHERE user_id = ?", userID)
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return types.User{}, err
+	}
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	return s.GetUserByID(userID)
+}
+
+// pruneRefreshTokensTx drops the tokens that expired
+func pruneRefreshTokensTx(tx *sql.Tx, now time.Time) error {
+	_, err := tx.Exec("DELETE FROM chirpy_refresh_tokens WHERE expires_at < ?", toMillis(now))
+	return err
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+const reportColumns = "id, chirp_id, reporter_id, reason, status, created_at, resolved_at"
+
+func scanReport(row interface{ Scan(...any) error }) (types.Report, error) {
+	var report types.Report
+	var createdAt, resolvedAt int64
+	err := row.Scan(&report.ID, &report.ChirpID, &report.ReporterID, &report.Reason, &report.Status, &createdAt, &resolvedAt)
+	if err != nil {
+		return report, err
+	}
+	report.CreatedAt = fromMillis(createdAt)
+	if resolvedAt != 0 {
+		t := fromMillis(resolvedAt)
+		report.ResolvedAt = &t
+	}
+	return report, nil
+}
+
+// CreateReport files a report about a visible chirp. A user can't have
+// two open reports about the same chirp.
+func (s *SQLDB) CreateReport(chirpID, reporterID int, reason string) (types.Report, error) {
+	report := types.Report{
+		ChirpID:    chirpID,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Status:     types.ReportOpen,
+		CreatedAt:  fromMillis(toMillis(time.Now())),
+	}
+	err := s.inTx(func(tx *sql.Tx) error {
+		var visible bool
+		err := tx.QueryRow("SELECT deleted = 0 AND "+publishedSQL+" FROM chirpy_chirps WHERE id = ?", chirpID).Scan(&visible)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !visible {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		var open int
+		err = tx.QueryRow(
+			"SELECT COUNT(*) FROM chirpy_reports WHERE chirp_id = ? AND reporter_id = ? AND status = ?",
+			chirpID, reporterID, types.ReportOpen,
+		).Scan(&open)
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrAlreadyReported
+		}
+		result, err := tx.Exec(
+			"INSERT INTO chirpy_reports (chirp_id, reporter_id, reason, status, created_at) VALUES (?, ?, ?, ?, ?)",
+			chirpID, reporterID, reason, report.Status, toMillis(report.CreatedAt),
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		report.ID = int(id)
+		return err
+	})
+	if errors.Is(err, ErrNotExist) || errors.Is(err, ErrAlreadyReported) {
+		return types.Report{}, err
+	}
+	if err != nil {
+		return types.Report{}, fmt.Errorf("failed to insert report: %v", err)
+	}
+	return report, nil
+}
+
+func (s *SQLDB) GetReport(id int) (types.Report, error) {
+	report, err := scanReport(s.db.QueryRow("SELECT "+reportColumns+" FROM chirpy_reports WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Report{}, ErrNotExist
+	}
+	if err != nil {
+		return types.Report{}, fmt.Errorf("error scanning row: %v", err)
+	}
+	return report, nil
+}
+
+// ListReports returns the reports with status, oldest first, or every
+// report when status is ""
+func (s *SQLDB) ListReports(status string) ([]types.Report, error) {
+	rows, err := s.db.Query(
+		"SELECT "+reportColumns+" FROM chirpy_reports WHERE ? = '' OR status = ? ORDER BY id",
+		status, status,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	reports := []types.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return reports, nil
+}
+
+// ResolveReport closes an open report with status, along with the other
+// open reports about the same chirp since they are handled by the same
+// decision
+func (s *SQLDB) ResolveReport(id int, status string) (types.Report, error) {
+	var resolved types.Report
+	err := s.inTx(func(tx *sql.Tx) error {
+		report, err := scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM chirpy_reports WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if report.Status != types.ReportOpen {
+			return ErrReportResolved
+		}
+		now := fromMillis(toMillis(time.Now()))
+		_, err = tx.Exec(
+			"UPDATE chirpy_reports SET status = ?, resolved_at = ? WHERE chirp_id = ? AND status = ?",
+			status, toMillis(now), report.ChirpID, types.ReportOpen,
+		)
+		report.Status = status
+		report.ResolvedAt = &now
+		resolved = report
+		return err
+	})
+	if errors.Is(err, ErrNotExist) || errors.Is(err, ErrReportResolved) {
+		return types.Report{}, err
+	}
+	if err != nil {
+		return types.Report{}, fmt.Errorf("failed to resolve report: %v", err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// RestoreChirp makes a deleted chirp visible again, as long as it
+// hasn't been purged
+func (s *SQLDB) RestoreChirp(id int) (types.Chirp, error) {
+	var restored types.Chirp
+	err := s.inTx(func(tx *sql.Tx) error {
+		chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !chirp.Restorable() {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE chirpy_chirps SET deleted = 0, deleted_at = 0, deleted_by = 0 WHERE id = ?", id)
+		chirp.Deleted = false
+		chirp.DeletedAt = nil
+		chirp.DeletedBy = 0
+		restored = chirp
+		return err
+	})
+	if errors.Is(err, ErrNotExist) {
+		return types.Chirp{}, err
+	}
+	if err != nil {
+		return types.Chirp{}, fmt.Errorf("failed to restore chirp: %v", err)
+	}
+	return restored, nil
+}
+
+// PurgeDeletedChirps permanently removes the chirps deleted before
+// before, with their history, reactions, bookmarks, notifications and
+// reports, and returns them as they were so their attachments can be
+// removed too. A purged chirp that has replies is kept as a tombstone so
+// the thread stays reachable; tombstones are removed once their last
+// reply is gone.
+func (s *SQLDB) PurgeDeletedChirps(before time.Time) ([]types.Chirp, error) {
+	rows, err := s.db.Query("SELECT id FROM chirpy_chirps WHERE "+purgeableSQL, toMillis(before))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	var purged []types.Chirp
+	for _, id := range ids {
+		var chirp types.Chirp
+		err := s.inTx(func(tx *sql.Tx) error {
+			// The chirp may have been restored, or deleted again, since
+			var err error
+			chirp, err = scanChirp(tx.QueryRow(
+				"SELECT "+chirpColumns+" FROM chirpy_chirps WHERE id = ? AND "+purgeableSQL,
+				id, toMillis(before),
+			))
+			if err != nil {
+				return err
+			}
+			return purgeTx(tx, chirp)
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge chirp: %v", err)
+		}
+		purged = append(purged, chirp)
+	}
+	return purged, nil
+}
+
+// purgeableSQL matches the chirps deleted before the time of its
+// parameter and not purged yet
+const purgeableSQL = "deleted = 1 AND deleted_at > 0 AND deleted_at < ?"
+
+func purgeTx(tx *sql.Tx, chirp types.Chirp) error {
+	id := chirp.Id
+	if _, err := tx.Exec("DELETE FROM chirpy_chirp_versions WHERE chirp_id = ?", id); err != nil {
+		return err
+	}
+	for _, table := range []string{"chirpy_reactions", "chirpy_chirp_hashtags", "chirpy_notifications", "chirpy_bookmarks", "chirpy_reports"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE chirp_id = ?", id); err != nil {
+			return err
+		}
+	}
+
+	var replies int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM chirpy_chirps WHERE parent_id = ?", id).Scan(&replies); err != nil {
+		return err
+	}
+	if replies > 0 {
+		_, err := tx.Exec(
+			"UPDATE chirpy_chirps SET body = '', hashtags = '', mentions = '', attachments = '', deleted_at = 0, updated_at = ? WHERE id = ?",
+			toMillis(time.Now()), id,
+		)
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM chirpy_chirps WHERE id = ?", id); err != nil {
+		return err
+	}
+	// Remove tombstones left without replies, walking up the thread
+	for parentID := chirp.ParentID; parentID != 0; {
+		var next int
+		err := tx.QueryRow(
+			`SELECT parent_id FROM chirpy_chirps p WHERE id = ? AND deleted = 1 AND deleted_at = 0
+			AND NOT EXISTS (SELECT 1 FROM chirpy_chirps r WHERE r.parent_id = p.id)`,
+			parentID,
+		).Scan(&next)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM chirpy_chirps WHERE id = ?", parentID); err != nil {
+			return err
+		}
+		parentID = next
+	}
+	return nil
+}
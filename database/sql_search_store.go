@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/erwaen/Chirpy/search"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// ftsMatch turns a parsed query into an FTS5 MATCH expression. Tokens
+// are only letters and digits, so quoting them is enough to keep FTS5
+// operators out.
+func ftsMatch(q search.Query) string {
+	var parts []string
+	for _, term := range q.Terms {
+		parts = append(parts, `"`+term+`"`)
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+strings.Join(phrase, " ")+`"`)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// SearchChirps returns the chirps matching q, best match first
+func (s *SQLDB) SearchChirps(q SearchQuery) ([]types.Chirp, error) {
+	query := search.ParseQuery(q.Text)
+	if query.Empty() {
+		return nil, ErrEmptySearch
+	}
+
+	columns := "c." + strings.ReplaceAll(chirpColumns, ", ", ", c.")
+	stmt := `WITH hits AS (
+		SELECT rowid AS id, bm25(chirpy_chirps_fts) AS rank
+		FROM chirpy_chirps_fts WHERE chirpy_chirps_fts MATCH ?
+	)
+	SELECT ` + columns + ` FROM hits JOIN chirpy_chirps c ON c.id = hits.id
+	WHERE c.deleted = 0 AND c.draft = 0 AND c.publish_at = 0`
+	args := []any{ftsMatch(query)}
+	if q.AuthorID != 0 {
+		stmt += " AND c.author_id = ?"
+		args = append(args, q.AuthorID)
+	}
+	// bm25 is lower for better matches
+	stmt += " ORDER BY hits.rank, c.id DESC"
+	if q.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	chirps := []types.Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return chirps, nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+const userColumns = "id, email, password, is_chirpy_red, suspended, token_version, role"
+
+func scanUser(row interface{ Scan(...any) error }) (types.User, error) {
+	var user types.User
+	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.IsChirpyRed, &user.Suspended, &user.TokenVersion, &user.Role)
+	return user, err
+}
+
+func (s *SQLDB) getUser(where string, arg any) (types.User, error) {
+	row := s.db.QueryRow("SELECT "+userColumns+" FROM chirpy_users WHERE "+where, arg)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.User{}, ErrNotExist
+	}
+	if err != nil {
+		return types.User{}, fmt.Errorf("error scanning row: %v", err)
+	}
+	return user, nil
+}
+
+// CreateUser creates a new user and saves it to the database
+func (s *SQLDB) CreateUser(email string, password string) (types.User, error) {
+	if _, err := s.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
+		if err != nil {
+			return types.User{}, err
+		}
+		return types.User{}, ErrUserAlreadyExist
+	}
+
+	result, err := s.db.Exec("INSERT INTO chirpy_users (email, password) VALUES (?, ?)", email, password)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to insert user: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to get last insert id: %v", err)
+	}
+	return types.User{
+		Id:       int(id),
+		Email:    email,
+		Password: password,
+		Role:     types.RoleUser,
+	}, nil
+}
+
+func (s *SQLDB) GetUserByEmail(email string) (types.User, error) {
+	return s.getUser("email = ?", email)
+}
+
+func (s *SQLDB) GetUserByID(id int) (types.User, error) {
+	return s.getUser("id = ?", id)
+}
+
+// GetUserByHandle finds the user a mention refers to: either their
+// email or, when no other user shares it, the part of their email
+// before the '@'. Both are compared ignoring case.
+func (s *SQLDB) GetUserByHandle(handle string) (types.User, error) {
+	user, err := s.getUser("lower(email) = lower(?)", handle)
+	if !errors.Is(err, ErrNotExist) {
+		return user, err
+	}
+	rows, err := s.db.Query(
+		"SELECT "+userColumns+" FROM chirpy_users WHERE lower(substr(email, 1, instr(email, '@') - 1)) = lower(?) LIMIT 2",
+		handle,
+	)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	var matches []types.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return types.User{}, fmt.Errorf("error scanning row: %v", err)
+		}
+		matches = append(matches, user)
+	}
+	if err := rows.Err(); err != nil {
+		return types.User{}, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	if len(matches) != 1 {
+		return types.User{}, ErrNotExist
+	}
+	return matches[0], nil
+}
+
+func (s *SQLDB) UpdateUser(id int, email, hashedPassword string) (types.User, error) {
+	result, err := s.db.Exec("UPDATE chirpy_users SET email = ?, password = ? WHERE id = ?", email, hashedPassword, id)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to update user: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return types.User{}, ErrNotExist
+	}
+	return s.GetUserByID(id)
+}
+
+func (s *SQLDB) UpgradeUserRed(userID int) (types.User, error) {
+	result, err := s.db.Exec("UPDATE chirpy_users SET is_chirpy_red = 1 WHERE id = ?", userID)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to upgrade user: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return types.User{}, ErrNotExist
+	}
+	return s.GetUserByID(userID)
+}
+
+// SuspendUser keeps a user from logging in and chirping
+func (s *SQLDB) SuspendUser(userID int) (types.User, error) {
+	result, err := s.db.Exec("UPDATE chirpy_users SET suspended = 1 WHERE id = ?", userID)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to suspend user: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return types.User{}, ErrNotExist
+	}
+	return s.GetUserByID(userID)
+}
+
+// SetUserRole changes the role of a user. Their token version is bumped
+// so their access tokens get the scopes of the new role at the next
+// refresh.
+func (s *SQLDB) SetUserRole(userID int, role string) (types.User, error) {
+	result, err := s.db.Exec("UPDATE chirpy_users SET role = ?, token_version = token_version + 1 WHERE id = ?", role, userID)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to set user role: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return types.User{}, ErrNotExist
+	}
+	return s.GetUserByID(userID)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"time"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// Store is the persistence layer used by the HTTP handlers. It is
+// implemented by DB (a JSON file on disk) and SQLDB (libsql/SQLite).
+type Store interface {
+	ResetDB() error
+
+	GetChirps(authorID int, sortBy string) ([]types.Chirp, error)
+	ListChirps(q ChirpQuery) ([]types.Chirp, error)
+	SearchChirps(q SearchQuery) ([]types.Chirp, error)
+	GetChirp(id int) (types.Chirp, error)
+	CreateChirp(chirp types.Chirp) (types.Chirp, error)
+	DeleteChirp(id, deletedBy int) (types.Chirp, error)
+	RestoreChirp(id int) (types.Chirp, error)
+	PurgeDeletedChirps(before time.Time) ([]types.Chirp, error)
+	UpdateChirp(chirp types.Chirp) (types.Chirp, error)
+	PublishChirp(id int) (types.Chirp, error)
+	PublishDueChirps(now time.Time) ([]types.Chirp, error)
+	GetChirpHistory(id int) ([]types.ChirpVersion, error)
+	AddAttachment(chirpID int, attachment types.Attachment) (types.Chirp, error)
+	GetChirpStats(ids []int, viewerID int) (map[int]ChirpStats, error)
+	TrendingHashtags(since time.Time, limit int) ([]HashtagCount, error)
+
+	AddReaction(kind string, chirpID, userID int) error
+	RemoveReaction(kind string, chirpID, userID int) error
+	AddBookmark(userID, chirpID int) error
+	RemoveBookmark(userID, chirpID int) error
+
+	CreateUser(email string, password string) (types.User, error)
+	GetUserByEmail(email string) (types.User, error)
+	GetUserByID(id int) (types.User, error)
+	GetUserByHandle(handle string) (types.User, error)
+	UpdateUser(id int, email, hashedPassword string) (types.User, error)
+	UpgradeUserRed(userID int) (types.User, error)
+	SuspendUser(userID int) (types.User, error)
+	SetUserRole(userID int, role string) (types.User, error)
+
+	CreateReport(chirpID, reporterID int, reason string) (types.Report, error)
+	GetReport(id int) (types.Report, error)
+	ListReports(status string) ([]types.Report, error)
+	ResolveReport(id int, status string) (types.Report, error)
+
+	Follow(followerID, followeeID int) error
+	Unfollow(followerID, followeeID int) error
+	GetFollowers(userID int) ([]types.User, error)
+	GetFollowing(userID int) ([]types.User, error)
+
+	GetNotifications(userID int) ([]types.Notification, error)
+
+	InsertRefreshToken(userID int, refreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error)
+	GetRefreshTokenStruct(refreshToken string) (types.RefreshToken, error)
+	RotateRefreshToken(refreshToken, newRefreshToken string, expiresAt time.Duration, userAgent, ip string) (types.RefreshToken, error)
+	RevokeRefreshToken(refreshToken string) (types.RefreshToken, error)
+	GetSessions(userID int) ([]types.RefreshToken, error)
+	RevokeSession(userID int, familyID string) error
+	RevokeAllSessions(userID int) (types.User, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLDB)(nil)
+)
@@ -2,6 +2,8 @@ package database
 
 import (
 	"errors"
+	"strings"
+
 	"github.com/erwaen/Chirpy/types"
 )
 
@@ -9,77 +11,109 @@ var ErrUserAlreadyExist = errors.New("User already exists")
 
 // CreateUser creates a new user and saves it to disk
 func (db *DB) CreateUser(email string, password string) (types.User, error) {
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return types.User{}, ErrUserAlreadyExist
-	}
-
-	allData, err := db.loadDB()
-	if err != nil {
-		return types.User{}, err
-	}
-
-	newID := 0
-	for id := range allData.Users {
-		if id > newID {
-			newID = id
+	var newUser types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		newID := 0
+		for id, user := range dbStructure.Users {
+			if user.Email == email {
+				return ErrUserAlreadyExist
+			}
+			if id > newID {
+				newID = id
+			}
 		}
-	}
-	newID++
-
-	newUser := types.User{
-		Id:       newID,
-		Email:    email,
-		Password: password,
-	}
-	allData.Users[newID] = newUser
+		newID++
 
-	err = db.writeDB(allData)
+		newUser = types.User{
+			Id:       newID,
+			Email:    email,
+			Password: password,
+			Role:     types.RoleUser,
+		}
+		usersTable.put(dbStructure, newID, newUser)
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
-
 	return newUser, nil
 }
 
 func (db *DB) GetUserByEmail(email string) (types.User, error) {
-	allDB, err := db.loadDB()
+	var found types.User
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, user := range dbStructure.Users {
+			if user.Email == email {
+				found = user
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
 	if err != nil {
 		return types.User{}, err
 	}
-	for _, user := range allDB.Users {
-		if user.Email == email {
-			return user, nil
+	return found, nil
+}
+
+// GetUserByHandle finds the user a mention refers to: either their
+// email or, when no other user shares it, the part of their email
+// before the '@'. Both are compared ignoring case.
+func (db *DB) GetUserByHandle(handle string) (types.User, error) {
+	var found types.User
+	err := db.View(func(dbStructure *DBStructure) error {
+		matches := 0
+		for _, user := range dbStructure.Users {
+			email := strings.ToLower(user.Email)
+			if email == strings.ToLower(handle) {
+				found = user
+				return nil
+			}
+			if local, _, ok := strings.Cut(email, "@"); ok && local == strings.ToLower(handle) {
+				found = user
+				matches++
+			}
 		}
+		if matches != 1 {
+			return ErrNotExist
+		}
+		return nil
+	})
+	if err != nil {
+		return types.User{}, err
 	}
-	return types.User{}, ErrNotExist
+	return found, nil
 }
 
 func (db *DB) GetUserByID(id int) (types.User, error) {
-	allDB, err := db.loadDB()
+	var user types.User
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
-	user, ok := allDB.Users[id]
-	if !ok {
-		return types.User{}, ErrNotExist
-	}
 	return user, nil
 }
 
 func (db *DB) UpdateUser(id int, email, hashedPassword string) (types.User, error) {
-	allDB, err := db.loadDB()
-	if err != nil {
-		return types.User{}, err
-	}
-	user, ok := allDB.Users[id]
-	if !ok {
-		return types.User{}, ErrNotExist
-	}
-
-	user.Email = email
-	user.Password = hashedPassword
-	allDB.Users[user.Id] = user
-	err = db.writeDB(allDB)
+	var user types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Email = email
+		user.Password = hashedPassword
+		usersTable.put(dbStructure, user.Id, user)
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
@@ -87,22 +121,60 @@ func (db *DB) UpdateUser(id int, email, hashedPassword string) (types.User, erro
 }
 
 func (db *DB) UpgradeUserRed(userID int) (types.User, error) {
-	user, err := db.GetUserByID(userID)
+	var user types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.IsChirpyRed = true
+		usersTable.put(dbStructure, user.Id, user)
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
-	dat, err := db.loadDB()
+	return user, nil
+}
+
+// SuspendUser keeps a user from logging in and chirping
+func (db *DB) SuspendUser(userID int) (types.User, error) {
+	var user types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Suspended = true
+		usersTable.put(dbStructure, user.Id, user)
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
+	return user, nil
+}
 
-	user.IsChirpyRed = true
-	dat.Users[user.Id] = user
-
-	err = db.writeDB(dat)
+// SetUserRole changes the role of a user. Their token version is bumped
+// so their access tokens get the scopes of the new role at the next
+// refresh.
+func (db *DB) SetUserRole(userID int, role string) (types.User, error) {
+	var user types.User
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		user.Role = role
+		user.TokenVersion++
+		usersTable.put(dbStructure, user.Id, user)
+		return nil
+	})
 	if err != nil {
 		return types.User{}, err
 	}
-
-	return user, err
+	return user, nil
 }
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/erwaen/Chirpy/types"
+)
+
+// walOp is a single entry put into or deleted from a collection. One
+// line of the write-ahead log holds all the ops of one Update.
+type walOp struct {
+	Table  string          `json:"table"`
+	Key    json.RawMessage `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Delete bool            `json:"delete,omitempty"`
+}
+
+// table knows how to log, roll back and replay the changes to one
+// collection of DBStructure. Every collection must be listed in tables.
+type table interface {
+	name() string
+	init(dbStructure *DBStructure)
+	// op returns the op turning the entry under key in before into the
+	// one in current, false when the entry didn't change
+	op(before, current *DBStructure, key any) (walOp, bool, error)
+	// restore puts back the entry under key as it was in before
+	restore(before, current *DBStructure, key any)
+	apply(dbStructure *DBStructure, op walOp) error
+}
+
+// mapTable is a table backed by a flat map. Inside an Update entries
+// must be written with put and del so the journal knows which ones
+// changed, and values must never be mutated in place.
+type mapTable[K comparable, V any] struct {
+	tableName string
+	field     func(*DBStructure) *map[K]V
+}
+
+var (
+	chirpsTable        = mapTable[int, types.Chirp]{"chirps", func(d *DBStructure) *map[int]types.Chirp { return &d.Chirps }}
+	usersTable         = mapTable[int, types.User]{"users", func(d *DBStructure) *map[int]types.User { return &d.Users }}
+	refreshTokensTable = mapTable[string, types.RefreshToken]{"refresh_tokens", func(d *DBStructure) *map[string]types.RefreshToken { return &d.RefreshTokens }}
+	chirpHistoryTable  = mapTable[int, []types.ChirpVersion]{"chirp_history", func(d *DBStructure) *map[int][]types.ChirpVersion { return &d.ChirpHistory }}
+	reactionsTable     = mapTable[string, types.Reaction]{"reactions", func(d *DBStructure) *map[string]types.Reaction { return &d.Reactions }}
+	followsTable       = mapTable[string, types.Follow]{"follows", func(d *DBStructure) *map[string]types.Follow { return &d.Follows }}
+	notificationsTable = mapTable[int, types.Notification]{"notifications", func(d *DBStructure) *map[int]types.Notification { return &d.Notifications }}
+	bookmarksTable     = mapTable[string, types.Bookmark]{"bookmarks", func(d *DBStructure) *map[string]types.Bookmark { return &d.Bookmarks }}
+	reportsTable       = mapTable[int, types.Report]{"reports", func(d *DBStructure) *map[int]types.Report { return &d.Reports }}
+	nextIDsTable       = mapTable[string, int]{"next_ids", func(d *DBStructure) *map[string]int { return &d.NextIDs }}
+)
+
+var tables = []table{
+	chirpsTable,
+	usersTable,
+	refreshTokensTable,
+	chirpHistoryTable,
+	reactionsTable,
+	followsTable,
+	notificationsTable,
+	bookmarksTable,
+	reportsTable,
+	nextIDsTable,
+}
+
+func (t mapTable[K, V]) name() string {
+	return t.tableName
+}
+
+func (t mapTable[K, V]) init(dbStructure *DBStructure) {
+	if *t.field(dbStructure) == nil {
+		*t.field(dbStructure) = map[K]V{}
+	}
+}
+
+// put stores value under key
+func (t mapTable[K, V]) put(dbStructure *DBStructure, key K, value V) {
+	t.record(dbStructure, key)
+	(*t.field(dbStructure))[key] = value
+}
+
+// del removes the entry under key
+func (t mapTable[K, V]) del(dbStructure *DBStructure, key K) {
+	t.record(dbStructure, key)
+	delete(*t.field(dbStructure), key)
+}
+
+// record tells the journal of the running Update that the entry under
+// key is about to change, keeping its value from before the Update
+func (t mapTable[K, V]) record(dbStructure *DBStructure, key K) {
+	j := dbStructure.journal
+	if j == nil {
+		return
+	}
+	entry := journalEntry{t.tableName, key}
+	if j.seen[entry] {
+		return
+	}
+	j.seen[entry] = true
+	j.touched = append(j.touched, touchedEntry{t, key})
+	if value, ok := (*t.field(dbStructure))[key]; ok {
+		(*t.field(&j.before))[key] = value
+	}
+}
+
+func (t mapTable[K, V]) op(before, current *DBStructure, key any) (walOp, bool, error) {
+	k := key.(K)
+	prev, existed := (*t.field(before))[k]
+	value, exists := (*t.field(current))[k]
+	if !exists && !existed || exists && existed && reflect.DeepEqual(prev, value) {
+		return walOp{}, false, nil
+	}
+	rawKey, err := json.Marshal(k)
+	if err != nil {
+		return walOp{}, false, err
+	}
+	if !exists {
+		return walOp{Table: t.tableName, Key: rawKey, Delete: true}, true, nil
+	}
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		return walOp{}, false, err
+	}
+	return walOp{Table: t.tableName, Key: rawKey, Value: rawValue}, true, nil
+}
+
+func (t mapTable[K, V]) restore(before, current *DBStructure, key any) {
+	k := key.(K)
+	if value, ok := (*t.field(before))[k]; ok {
+		(*t.field(current))[k] = value
+	} else {
+		delete(*t.field(current), k)
+	}
+}
+
+func (t mapTable[K, V]) apply(dbStructure *DBStructure, op walOp) error {
+	var key K
+	if err := json.Unmarshal(op.Key, &key); err != nil {
+		return err
+	}
+	m := *t.field(dbStructure)
+	if op.Delete {
+		delete(m, key)
+		return nil
+	}
+	var value V
+	if err := json.Unmarshal(op.Value, &value); err != nil {
+		return err
+	}
+	m[key] = value
+	return nil
+}
+
+// journal records the entries an Update touches with their values from
+// before it, so an Update costs as much as the entries it changes
+// rather than the size of the database
+type journal struct {
+	// before holds the previous values of the touched entries that
+	// existed, in the same collections as the database so indexes can
+	// look them up
+	before  DBStructure
+	touched []touchedEntry
+	seen    map[journalEntry]bool
+}
+
+type journalEntry struct {
+	table string
+	key   any
+}
+
+type touchedEntry struct {
+	table table
+	key   any
+}
+
+func newJournal() *journal {
+	j := &journal{seen: map[journalEntry]bool{}}
+	j.before.ensureMaps()
+	return j
+}
+
+// ops lists the ops that turn the touched entries into their values in
+// current
+func (j *journal) ops(current *DBStructure) ([]walOp, error) {
+	var ops []walOp
+	for _, entry := range j.touched {
+		op, changed, err := entry.table.op(&j.before, current, entry.key)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// rollback undoes the changes to the touched entries of current
+func (j *journal) rollback(current *DBStructure) {
+	for i := len(j.touched) - 1; i >= 0; i-- {
+		j.touched[i].table.restore(&j.before, current, j.touched[i].key)
+	}
+}
+
+// ensureMaps initializes collections missing from an older file so
+// mutators can write to them without checking for nil
+func (dbStructure *DBStructure) ensureMaps() {
+	for _, t := range tables {
+		t.init(dbStructure)
+	}
+}
+
+func (dbStructure *DBStructure) apply(ops []walOp) error {
+	for _, op := range ops {
+		applied := false
+		for _, t := range tables {
+			if t.name() == op.Table {
+				if err := t.apply(dbStructure, op); err != nil {
+					return err
+				}
+				applied = true
+				break
+			}
+		}
+		if !applied {
+			return errors.New("unknown table in write-ahead log: " + op.Table)
+		}
+	}
+	return nil
+}
+
+func (db *DB) walPath() string {
+	return db.path + ".wal"
+}
+
+// openWAL opens the write-ahead log and replays it on top of db.data.
+// A torn last record, left by a crash in the middle of an append, is
+// cut off.
+func (db *DB) openWAL() error {
+	f, err := os.OpenFile(db.walPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("dropping incomplete record at the end of %s", db.walPath())
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		var ops []walOp
+		if err := json.Unmarshal(bytes.TrimSpace(line), &ops); err != nil {
+			log.Printf("dropping unreadable record at the end of %s: %v", db.walPath(), err)
+			break
+		}
+		if err := db.data.apply(ops); err != nil {
+			f.Close()
+			return err
+		}
+		offset += int64(len(line))
+		db.walRecords++
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+
+	db.wal = f
+	return nil
+}
+
+// appendWAL durably records the ops of one Update
+func (db *DB) appendWAL(ops []walOp) error {
+	dat, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	dat = append(dat, '\n')
+	if _, err := db.wal.Write(dat); err != nil {
+		return err
+	}
+	if err := db.wal.Sync(); err != nil {
+		return err
+	}
+	db.walRecords++
+	return nil
+}
+
+// compact writes the in-memory state as a new snapshot and empties the
+// write-ahead log. Callers must hold the write lock.
+func (db *DB) compact() error {
+	if err := db.writeFile(db.data); err != nil {
+		return err
+	}
+	if err := db.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := db.wal.Sync(); err != nil {
+		return err
+	}
+	db.walRecords = 0
+	return nil
+}
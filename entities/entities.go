@@ -0,0 +1,59 @@
+// Package entities finds the hashtags and mentions of a chirp body
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Hashtags returns the #tags of body, lowercased and without the '#', in
+// order of first appearance. A tag starts at the beginning of the body
+// or after a space, is made of letters, digits and underscores and
+// contains at least one letter, so "#1" and "page#2" are not tags.
+func Hashtags(body string) []string {
+	return extract(body, '#', isTagRune, func(tag string) bool {
+		return strings.IndexFunc(tag, unicode.IsLetter) >= 0
+	})
+}
+
+// Mentions returns the @names of body, lowercased and without the '@',
+// in order of first appearance. A name is a handle or an email address;
+// trailing punctuation like in "@bob." is not part of it.
+func Mentions(body string) []string {
+	return extract(body, '@', isMentionRune, func(name string) bool {
+		return name != ""
+	})
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+func isMentionRune(r rune) bool {
+	return isTagRune(r) || strings.ContainsRune(".-+@", r)
+}
+
+func extract(body string, sigil rune, inWord func(rune) bool, valid func(string) bool) []string {
+	var found []string
+	seen := map[string]bool{}
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != sigil || i > 0 && !unicode.IsSpace(runes[i-1]) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && inWord(runes[end]) {
+			end++
+		}
+		word := strings.ToLower(strings.TrimRightFunc(string(runes[i+1:end]), func(r rune) bool {
+			return !isTagRune(r)
+		}))
+		i = end - 1
+		if !valid(word) || seen[word] {
+			continue
+		}
+		seen[word] = true
+		found = append(found, word)
+	}
+	return found
+}
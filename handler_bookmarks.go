@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/erwaen/Chirpy/database"
+)
+
+// handlerBookmark returns the handler saving a chirp for the user, or
+// removing it from their bookmarks when remove is true. Both directions
+// are idempotent and answer with the chirp.
+func (cfg *apiConfig) handlerBookmark(remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := authUser(r).Id
+
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+			return
+		}
+
+		if remove {
+			err = cfg.db.RemoveBookmark(userID, chirpID)
+		} else {
+			err = cfg.db.AddBookmark(userID, chirpID)
+		}
+		if err != nil {
+			if errors.Is(err, database.ErrNotExist) {
+				respondWithError(w, http.StatusNotFound, "Chirp Not found")
+			} else {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't save the bookmark")
+			}
+			return
+		}
+
+		chirp, err := cfg.db.GetChirp(chirpID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+			return
+		}
+		resp, err := cfg.publicChirp(chirp, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+			return
+		}
+		respondWithJson(w, http.StatusOK, resp)
+	}
+}
+
+// handlerBookmarks lists the chirps the user bookmarked, the latest
+// bookmark first. It is always paginated.
+func (cfg *apiConfig) handlerBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{
+		BookmarkedBy: userID,
+		OrderBy:      database.OrderByBookmarkedAt,
+		Sort:         "desc",
+	}, true)
+}
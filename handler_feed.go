@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// feedSize is how many of the latest chirps a feed lists
+const feedSize = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int    `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []atomLink `xml:"link"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID       `xml:"guid"`
+	Link        string        `xml:"link"`
+	Title       string        `xml:"title"`
+	Description string        `xml:"description"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// parsePublicURL reads PUBLIC_URL, the absolute URL the API is served
+// at, like "https://anniagumi.lat"
+func parsePublicURL(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid public URL %q", s)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// baseURL is the URL the absolute links and IDs of feeds start with:
+// PUBLIC_URL, so they stay the same whichever host or proxy the feed is
+// read through, or else the scheme and host the request was made to.
+// The X-Forwarded-Proto and X-Forwarded-Host headers are only read from
+// trusted proxies, otherwise anyone could make the links of a cached
+// feed point to their own site.
+func (cfg *apiConfig) baseURL(r *http.Request) string {
+	if cfg.publicURL != "" {
+		return cfg.publicURL
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if cfg.trustedProxy(remoteIP(r)) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// feedTitle is the first line of a chirp, shortened for feed readers
+// listing entries by title
+func feedTitle(body string) string {
+	title, _, _ := strings.Cut(body, "\n")
+	if runes := []rune(title); len(runes) > 80 {
+		title = string(runes[:79]) + "…"
+	}
+	return title
+}
+
+// handlerFeed returns the handler rendering the latest chirps of the
+// user in the path as an Atom or RSS feed. Feed readers polling it get
+// 304 Not Modified through the ETag header. There is no Last-Modified
+// header: the latest update of the listed chirps goes back in time when
+// the latest one is deleted, and readers would keep the stale feed.
+func (cfg *apiConfig) handlerFeed(atom bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		user, err := cfg.db.GetUserByID(userID)
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting user: %s", err))
+			return
+		}
+		chirps, err := cfg.db.ListChirps(database.ChirpQuery{
+			AuthorID: userID,
+			OrderBy:  database.OrderByID,
+			Sort:     "desc",
+			Limit:    feedSize,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting chirps: %s", err))
+			return
+		}
+
+		var modified time.Time
+		for _, chirp := range chirps {
+			if chirp.UpdatedAt.After(modified) {
+				modified = chirp.UpdatedAt
+			}
+		}
+
+		handle := userHandle(user)
+		base := cfg.baseURL(r)
+		var feed any
+		contentType := "application/rss+xml; charset=utf-8"
+		if atom {
+			feed = atomFeedOf(base, r.URL.Path, handle, modified, chirps)
+			contentType = "application/atom+xml; charset=utf-8"
+		} else {
+			feed = rssFeedOf(base, userID, handle, modified, chirps)
+		}
+		dat, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error rendering feed: %s", err))
+			return
+		}
+		dat = append([]byte(xml.Header), dat...)
+
+		sum := sha256.Sum256(dat)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(dat))
+	}
+}
+
+func atomFeedOf(base, path, handle string, modified time.Time, chirps []types.Chirp) atomFeed {
+	if modified.IsZero() {
+		modified = time.Unix(0, 0)
+	}
+	feed := atomFeed{
+		ID:      base + path,
+		Title:   "Chirps by " + handle,
+		Updated: modified.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: handle},
+		Links:   []atomLink{{Href: base + path, Rel: "self", Type: "application/atom+xml"}},
+		Entries: []atomEntry{},
+	}
+	for _, chirp := range chirps {
+		url := fmt.Sprintf("%s/api/chirps/%d", base, chirp.Id)
+		entry := atomEntry{
+			ID:        url,
+			Title:     feedTitle(chirp.Body),
+			Published: chirp.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   chirp.UpdatedAt.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Href: url, Rel: "alternate", Type: "application/json"}},
+			Content:   atomText{Type: "text", Body: chirp.Body},
+		}
+		for _, a := range chirp.Attachments {
+			entry.Links = append(entry.Links, atomLink{
+				Href:   base + "/media/" + a.Key,
+				Rel:    "enclosure",
+				Type:   a.ContentType,
+				Length: a.Size,
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func rssFeedOf(base string, userID int, handle string, modified time.Time, chirps []types.Chirp) rssFeed {
+	channel := rssChannel{
+		Title:       "Chirps by " + handle,
+		Link:        fmt.Sprintf("%s/api/chirps?author_id=%d", base, userID),
+		Description: "The latest chirps by " + handle,
+		Items:       []rssItem{},
+	}
+	if !modified.IsZero() {
+		channel.LastBuildDate = modified.UTC().Format(time.RFC1123Z)
+	}
+	for _, chirp := range chirps {
+		url := fmt.Sprintf("%s/api/chirps/%d", base, chirp.Id)
+		item := rssItem{
+			GUID:        rssGUID{IsPermaLink: true, ID: url},
+			Link:        url,
+			Title:       feedTitle(chirp.Body),
+			Description: chirp.Body,
+			PubDate:     chirp.CreatedAt.UTC().Format(time.RFC1123Z),
+		}
+		// RSS only allows one enclosure per item
+		if len(chirp.Attachments) > 0 {
+			a := chirp.Attachments[0]
+			item.Enclosure = &rssEnclosure{URL: base + "/media/" + a.Key, Length: a.Size, Type: a.ContentType}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	return rssFeed{Version: "2.0", Channel: channel}
+}
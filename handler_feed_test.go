@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBaseURL(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{trustedProxies: proxies}
+
+	tests := []struct {
+		name   string
+		remote string
+		proto  string
+		host   string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.7:4000", want: "http://chirpy.test"},
+		{name: "untrusted forwarded headers", remote: "203.0.113.7:4000", proto: "https", host: "evil.example", want: "http://chirpy.test"},
+		{name: "trusted proxy", remote: "10.1.2.3:4000", proto: "https", host: "anniagumi.lat", want: "https://anniagumi.lat"},
+		{name: "trusted proxy without host", remote: "10.1.2.3:4000", proto: "https", want: "https://chirpy.test"},
+		{name: "invalid scheme", remote: "10.1.2.3:4000", proto: "javascript", want: "http://chirpy.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://chirpy.test/api/users/1/feed.atom", nil)
+			r.RemoteAddr = tt.remote
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.host != "" {
+				r.Header.Set("X-Forwarded-Host", tt.host)
+			}
+			if got := cfg.baseURL(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	cfg.publicURL = "https://anniagumi.lat"
+	r := httptest.NewRequest("GET", "http://chirpy.test/api/users/1/feed.atom", nil)
+	r.Header.Set("X-Forwarded-Host", "evil.example")
+	if got := cfg.baseURL(r); got != cfg.publicURL {
+		t.Errorf("got %q, want PUBLIC_URL %q", got, cfg.publicURL)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// PublicUser is what anyone may see of a user: never their email
+type PublicUser struct {
+	ID     int    `json:"id"`
+	Handle string `json:"handle"`
+}
+
+// userHandle is the name a user is mentioned by, the local part of
+// their email
+func userHandle(user types.User) string {
+	handle, _, _ := strings.Cut(user.Email, "@")
+	return handle
+}
+
+func publicUsersFromDB(users []types.User) []PublicUser {
+	resp := make([]PublicUser, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, PublicUser{
+			ID:     user.Id,
+			Handle: userHandle(user),
+		})
+	}
+	return resp
+}
+
+// handlerFollow returns the handler following the user in the path, or
+// unfollowing them when remove is true. Both directions are idempotent.
+func (cfg *apiConfig) handlerFollow(remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := authUser(r).Id
+
+		followeeID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+			return
+		}
+
+		if remove {
+			err = cfg.db.Unfollow(userID, followeeID)
+		} else {
+			err = cfg.db.Follow(userID, followeeID)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrNotExist):
+				respondWithError(w, http.StatusNotFound, "User Not found")
+			case errors.Is(err, database.ErrSelfFollow):
+				respondWithError(w, http.StatusBadRequest, err.Error())
+			default:
+				respondWithError(w, http.StatusInternalServerError, "Couldn't update the follow")
+			}
+			return
+		}
+		respondWithoutJson(w, http.StatusNoContent)
+	}
+}
+
+func (cfg *apiConfig) handlerFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.db.GetFollowers)
+}
+
+func (cfg *apiConfig) handlerFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.db.GetFollowing)
+}
+
+func (cfg *apiConfig) respondWithFollowList(w http.ResponseWriter, r *http.Request, list func(userID int) ([]types.User, error)) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+	users, err := list(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "User Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting users: %s", err))
+		}
+		return
+	}
+	respondWithJson(w, http.StatusOK, publicUsersFromDB(users))
+}
+
+// handlerTimeline lists the chirps of the authors the user follows,
+// newest first. It is always paginated.
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{
+		FollowedBy: userID,
+		OrderBy:    database.OrderByCreatedAt,
+		Sort:       "desc",
+	}, true)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/entities"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	defaultTrendingLimit  = 10
+)
+
+// extractEntities finds the hashtags of body and the users it mentions.
+// Mentions that don't match a user are ignored.
+func (cfg *apiConfig) extractEntities(body string) (hashtags []string, mentions []int, err error) {
+	for _, handle := range entities.Mentions(body) {
+		user, err := cfg.db.GetUserByHandle(handle)
+		if errors.Is(err, database.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !slices.Contains(mentions, user.Id) {
+			mentions = append(mentions, user.Id)
+		}
+	}
+	return entities.Hashtags(body), mentions, nil
+}
+
+// parseTrendingWindow reads TRENDING_WINDOW, a Go duration like "24h"
+func parseTrendingWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultTrendingWindow, nil
+	}
+	window, err := time.ParseDuration(s)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid trending window %q", s)
+	}
+	return window, nil
+}
+
+// handlerTrendingHashtags lists the most used hashtags of the chirps
+// created during the last window, which defaults to TRENDING_WINDOW
+func (cfg *apiConfig) handlerTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	window := cfg.trendingWindow
+	if s := r.URL.Query().Get("window"); s != "" {
+		var err error
+		window, err = parseTrendingWindow(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit := defaultTrendingLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(limit, maxPageSize)
+	}
+
+	counts, err := cfg.db.TrendingHashtags(time.Now().Add(-window), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting hashtags: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, counts)
+}
+
+func (cfg *apiConfig) handlerNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	notifications, err := cfg.db.GetNotifications(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting notifications: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, notifications)
+}
+
+// hashtagParam normalizes the hashtag query parameter, which may be
+// given with or without its '#'
+func hashtagParam(r *http.Request) string {
+	return strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("hashtag"), "#"))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/erwaen/Chirpy/auth"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. A new key has
+// to be published, as a retired key, for at least this long before it
+// starts signing.
+const jwksMaxAge = 5 * time.Minute
+
+// newKeyring loads the signing keys from dir, falling back to HS256 with
+// secret when no directory is configured. With both, secret only
+// verifies the tokens it signed before the switch to dir.
+func newKeyring(dir, secret string) (*auth.Keyring, error) {
+	if dir == "" {
+		return auth.NewHMACKeyring(secret), nil
+	}
+	keyring, err := auth.LoadKeyring(dir)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		if err := keyring.RetireHMAC(secret); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+// handlerJWKS publishes the public keys access tokens are signed with
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	respondWithJson(w, http.StatusOK, cfg.keys.JWKS())
+}
@@ -40,6 +40,10 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Invalid password")
 		return
 	}
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "Your account is suspended")
+		return
+	}
 
 	defaultExpiration := 60 * 60
 	if params.ExpiresInSeconds == 0 {
@@ -48,7 +52,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		params.ExpiresInSeconds = defaultExpiration
 	}
 
-	token, err := auth.MakeJWT(user.Id, cfg.jwtSecret, time.Duration(params.ExpiresInSeconds)*time.Second)
+	token, err := auth.MakeJWT(user, cfg.keys, time.Duration(params.ExpiresInSeconds)*time.Second)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT")
 		return
@@ -58,7 +62,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create Refresh Token")
 		return
 	}
-	_, err = cfg.db.InsertRefreshToken(user.Id, refreshToken, 60*24*time.Hour)
+	_, err = cfg.db.InsertRefreshToken(user.Id, refreshToken, refreshTokenExpiration, r.UserAgent(), cfg.clientIP(r))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token in db")
 		return
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erwaen/Chirpy/blob"
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/media"
+	"github.com/erwaen/Chirpy/types"
+)
+
+const defaultMaxUploadBytes = 5 << 20
+
+// Attachment is the public representation of types.Attachment
+type Attachment struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ContentType  string `json:"content_type"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+func attachmentsFromDB(attachments []types.Attachment) []Attachment {
+	if len(attachments) == 0 {
+		return nil
+	}
+	resp := make([]Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		resp = append(resp, Attachment{
+			URL:          "/media/" + a.Key,
+			ThumbnailURL: "/media/" + a.ThumbnailKey,
+			ContentType:  a.ContentType,
+			Size:         a.Size,
+			Width:        a.Width,
+			Height:       a.Height,
+		})
+	}
+	return resp
+}
+
+// parseMaxUploadBytes reads MEDIA_MAX_BYTES
+func parseMaxUploadBytes(s string) (int64, error) {
+	if s == "" {
+		return defaultMaxUploadBytes, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid media size limit %q", s)
+	}
+	return n, nil
+}
+
+func newBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handlerUploadAttachment adds the image in the "file" field of a
+// multipart form to a chirp. Only the author can do it.
+func (cfg *apiConfig) handlerUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+	chirp, err := cfg.db.GetChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+		}
+		return
+	}
+	if chirp.Deleted {
+		respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		return
+	}
+	if chirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not allowed to edit this chirp")
+		return
+	}
+
+	// Leave some room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxUploadBytes+64<<10)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		} else {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read the file field")
+		}
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, cfg.maxUploadBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read the file")
+		return
+	}
+	if int64(len(data)) > cfg.maxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	img, err := media.Process(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			respondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, media.ErrTooManyPixels):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't process the image")
+		}
+		return
+	}
+
+	base, err := newBlobKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store the image")
+		return
+	}
+	attachment := types.Attachment{
+		Key:          base + img.Ext,
+		ContentType:  img.ContentType,
+		Size:         len(data),
+		Width:        img.Width,
+		Height:       img.Height,
+		ThumbnailKey: base + "-thumb" + img.ThumbnailExt,
+	}
+	if err := cfg.blobs.Put(attachment.Key, bytes.NewReader(data)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store the image")
+		return
+	}
+	if err := cfg.blobs.Put(attachment.ThumbnailKey, bytes.NewReader(img.Thumbnail)); err != nil {
+		cfg.deleteBlobs([]types.Attachment{attachment})
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store the image")
+		return
+	}
+
+	updated, err := cfg.db.AddAttachment(chirpID, attachment)
+	if err != nil {
+		cfg.deleteBlobs([]types.Attachment{attachment})
+		switch {
+		case errors.Is(err, database.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		case errors.Is(err, database.ErrTooManyAttachments):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save the attachment")
+		}
+		return
+	}
+	resp, err := cfg.publicChirp(updated, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+		return
+	}
+	respondWithJson(w, http.StatusCreated, resp)
+}
+
+// deleteBlobs removes the files of attachments that are no longer
+// referenced. Failures only leave orphaned files, so they are logged.
+func (cfg *apiConfig) deleteBlobs(attachments []types.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.Key, a.ThumbnailKey} {
+			if err := cfg.blobs.Delete(key); err != nil {
+				log.Printf("Error deleting blob %s: %s", key, err)
+			}
+		}
+	}
+}
+
+// handlerMedia serves an uploaded image or thumbnail. Keys are random
+// and never reused, so the response can be cached forever.
+func (cfg *apiConfig) handlerMedia(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	f, err := cfg.blobs.Open(key)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Media Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read the media")
+		}
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, key, time.Time{}, f)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/moderation"
+)
+
+func (cfg *apiConfig) handlerModerationReload(w http.ResponseWriter, r *http.Request) {
+	err := cfg.moderator.Reload()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't reload moderation rules: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, cfg.moderator.Config())
+}
+
+func (cfg *apiConfig) handlerModerationRules(w http.ResponseWriter, r *http.Request) {
+	respondWithJson(w, http.StatusOK, cfg.moderator.Config())
+}
+
+// handlerFlaggedChirps lists the chirps flagged for review, newest
+// first unless the request asks for another order
+func (cfg *apiConfig) handlerFlaggedChirps(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{Flagged: true, OrderBy: database.OrderByID, Sort: "desc"}, false)
+}
+
+// newModerator loads the moderation rules from the JSON file at path,
+// or the built-in rules when path is empty
+func newModerator(path string) (*moderation.Moderator, error) {
+	return moderation.NewModerator(moderation.FileSource{Path: path})
+}
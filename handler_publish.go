@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+)
+
+const defaultSchedulerInterval = 10 * time.Second
+
+// parseSchedulerInterval reads SCHEDULER_INTERVAL, a Go duration like
+// "10s"
+func parseSchedulerInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultSchedulerInterval, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid scheduler interval %q", s)
+	}
+	return interval, nil
+}
+
+// runScheduler publishes the scheduled chirps that are due, once right
+// away so chirps due while the server was down go out on startup, then
+// every interval. It never returns.
+func (cfg *apiConfig) runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		published, err := cfg.db.PublishDueChirps(time.Now())
+		if err != nil {
+			log.Printf("Error publishing scheduled chirps: %s", err)
+		} else if len(published) > 0 {
+			log.Printf("Published %d scheduled chirps", len(published))
+		}
+		<-ticker.C
+	}
+}
+
+// handlerPublishChirp publishes a draft or scheduled chirp right away
+func (cfg *apiConfig) handlerPublishChirp(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+	chirp, err := cfg.db.GetChirp(chirpID)
+	if err == nil && (chirp.Deleted || !canSee(chirp, userID)) {
+		err = database.ErrNotExist
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+		}
+		return
+	}
+	if chirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not allowed to publish this chirp")
+		return
+	}
+
+	published, err := cfg.db.PublishChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't publish the chirp")
+		}
+		return
+	}
+	resp, err := cfg.publicChirp(published, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+		return
+	}
+	respondWithJson(w, http.StatusOK, resp)
+}
+
+// handlerDrafts lists the drafts and scheduled chirps of the user
+func (cfg *apiConfig) handlerDrafts(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	cfg.respondWithChirpList(w, r, database.ChirpQuery{AuthorID: userID, Unpublished: true}, false)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/erwaen/Chirpy/database"
+)
+
+// handlerReaction returns the handler liking or reposting a chirp, or
+// undoing it when remove is true. Both directions are idempotent and
+// answer with the chirp's updated counts.
+func (cfg *apiConfig) handlerReaction(kind string, remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := authUser(r).Id
+
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+			return
+		}
+
+		if remove {
+			err = cfg.db.RemoveReaction(kind, chirpID, userID)
+		} else {
+			err = cfg.db.AddReaction(kind, chirpID, userID)
+		}
+		if err != nil {
+			if errors.Is(err, database.ErrNotExist) {
+				respondWithError(w, http.StatusNotFound, "Chirp Not found")
+			} else {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't save the "+kind)
+			}
+			return
+		}
+
+		chirp, err := cfg.db.GetChirp(chirpID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+			return
+		}
+		resp, err := cfg.publicChirp(chirp, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get the chirp")
+			return
+		}
+		respondWithJson(w, http.StatusOK, resp)
+	}
+}
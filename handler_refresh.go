@@ -9,9 +9,15 @@ import (
 	"github.com/erwaen/Chirpy/database"
 )
 
+// refreshTokenExpiration is how long a refresh token can be used. Every
+// refresh hands out a new one, so a session lasts as long as it is used
+// at least this often.
+const refreshTokenExpiration = 60 * 24 * time.Hour
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -20,40 +26,60 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rfStruct, err := cfg.db.GetRefreshTokenStruct(refreshToken)
+	// Suspended users are turned away before their token is rotated, so
+	// their session is left as it was
+	rf, err := cfg.db.GetRefreshTokenStruct(refreshToken)
 	if err != nil {
 		if errors.Is(err, database.ErrNotExist) {
 			respondWithError(w, http.StatusUnauthorized, "Refresh token doesn't exist")
 		} else {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token from db")
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve refresh token")
 		}
 		return
 	}
-	if time.Now().After(rfStruct.ExpireAt) {
-		respondWithError(w, http.StatusUnauthorized, "Refresh Token expired")
+	user, err := cfg.db.GetUserByID(rf.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusUnauthorized, "User doesnt exist")
+		} else {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't Retrieve de user")
+		}
+		return
+	}
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "Your account is suspended")
 		return
 	}
 
-	userID := rfStruct.UserID
-	user, err := cfg.db.GetUserByID(userID)
+	newRefreshToken, err := auth.MakeRefreshT()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create Refresh Token")
+		return
+	}
+	_, err = cfg.db.RotateRefreshToken(refreshToken, newRefreshToken, refreshTokenExpiration, r.UserAgent(), cfg.clientIP(r))
 	if err != nil {
 		if errors.Is(err, database.ErrNotExist) {
-			respondWithError(w, http.StatusUnauthorized, "User doesnt exist")
+			respondWithError(w, http.StatusUnauthorized, "Refresh token doesn't exist")
+		} else if errors.Is(err, database.ErrTokenExpired) {
+			respondWithError(w, http.StatusUnauthorized, "Refresh Token expired")
+		} else if errors.Is(err, database.ErrTokenReused) {
+			respondWithError(w, http.StatusUnauthorized, "Refresh token was already used, log in again")
 		} else {
-			respondWithError(w, http.StatusUnauthorized, "Couldn't Retrieve de user")
+			respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token")
 		}
 		return
 	}
 
 	defaultExpiration := 60 * 60
-	token, err := auth.MakeJWT(user.Id, cfg.jwtSecret, time.Duration(defaultExpiration)*time.Second)
+	token, err := auth.MakeJWT(user, cfg.keys, time.Duration(defaultExpiration)*time.Second)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT")
 		return
 	}
 
 	respondWithJson(w, 200, response{
-		Token: token,
+		Token:        token,
+		RefreshToken: newRefreshToken,
 	})
 
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+const maxReportReasonLength = 500
+
+// reportActions maps the actions moderators resolve reports with to the
+// status they leave the reports in
+var reportActions = map[string]string{
+	"dismiss": types.ReportDismissed,
+	"hide":    types.ReportHidden,
+	"suspend": types.ReportSuspended,
+}
+
+// handlerReportChirp files a report about a chirp for the moderators
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	userID := authUser(r).Id
+
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	reason := strings.TrimSpace(params.Reason)
+	if reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("The reason can't be longer than %d characters", maxReportReasonLength))
+		return
+	}
+
+	report, err := cfg.db.CreateReport(chirpID, userID, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Chirp Not found")
+		case errors.Is(err, database.ErrAlreadyReported):
+			respondWithError(w, http.StatusConflict, "You already reported this chirp")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save the report")
+		}
+		return
+	}
+	respondWithJson(w, http.StatusCreated, report)
+}
+
+// handlerReports is the moderation queue: the open reports, oldest
+// first, with the chirp they are about. ?status= lists the reports
+// with another status, "all" lists every report.
+func (cfg *apiConfig) handlerReports(w http.ResponseWriter, r *http.Request) {
+	type queuedReport struct {
+		types.Report
+		// Chirp is nil once the chirp is purged
+		Chirp *types.Chirp `json:"chirp,omitempty"`
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = types.ReportOpen
+	case "all":
+		status = ""
+	}
+
+	reports, err := cfg.db.ListReports(status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting reports: %s", err))
+		return
+	}
+	resp := make([]queuedReport, 0, len(reports))
+	for _, report := range reports {
+		item := queuedReport{Report: report}
+		chirp, err := cfg.db.GetChirp(report.ChirpID)
+		if err == nil {
+			item.Chirp = &chirp
+		} else if !errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting chirp: %s", err))
+			return
+		}
+		resp = append(resp, item)
+	}
+	respondWithJson(w, http.StatusOK, resp)
+}
+
+// handlerResolveReport resolves a report with an action: "dismiss"
+// closes it, "hide" deletes the chirp and "suspend" suspends its
+// author. The other open reports about the chirp are resolved with it.
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Action string `json:"action"`
+	}
+
+	reportID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	status, ok := reportActions[params.Action]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, `action must be "dismiss", "hide" or "suspend"`)
+		return
+	}
+
+	report, err := cfg.db.GetReport(reportID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Report not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting report: %s", err))
+		}
+		return
+	}
+	if report.Status != types.ReportOpen {
+		respondWithError(w, http.StatusConflict, "Report already resolved")
+		return
+	}
+
+	switch status {
+	case types.ReportHidden:
+		// The author may have deleted it in the meantime
+		_, err := cfg.db.DeleteChirp(report.ChirpID, authUser(r).Id)
+		if err != nil && !errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't hide the chirp: %s", err))
+			return
+		}
+	case types.ReportSuspended:
+		chirp, err := cfg.db.GetChirp(report.ChirpID)
+		if err == nil {
+			_, err = cfg.db.SuspendUser(chirp.AuthorID)
+		}
+		if err != nil {
+			if errors.Is(err, database.ErrNotExist) {
+				respondWithError(w, http.StatusNotFound, "The chirp's author doesn't exist anymore")
+			} else {
+				respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't suspend the author: %s", err))
+			}
+			return
+		}
+	}
+
+	resolved, err := cfg.db.ResolveReport(reportID, status)
+	if err != nil {
+		if errors.Is(err, database.ErrReportResolved) {
+			respondWithError(w, http.StatusConflict, "Report already resolved")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't resolve the report: %s", err))
+		}
+		return
+	}
+	respondWithJson(w, http.StatusOK, resolved)
+}
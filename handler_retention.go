@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+const (
+	defaultRetentionPeriod = 30 * 24 * time.Hour
+	purgeInterval          = time.Hour
+)
+
+// parseRetentionPeriod reads DELETED_CHIRP_RETENTION, a Go duration
+// like "720h"
+func parseRetentionPeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultRetentionPeriod, nil
+	}
+	period, err := time.ParseDuration(s)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid retention period %q", s)
+	}
+	return period, nil
+}
+
+// runPurger permanently removes the chirps deleted more than retention
+// ago, with their attachments, once right away and then every
+// purgeInterval. It never returns.
+func (cfg *apiConfig) runPurger(retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := cfg.db.PurgeDeletedChirps(time.Now().Add(-retention))
+		// Chirps purged before an error are gone, so are their files
+		for _, chirp := range purged {
+			cfg.deleteBlobs(chirp.Attachments)
+		}
+		if err != nil {
+			log.Printf("Error purging deleted chirps: %s", err)
+		} else if len(purged) > 0 {
+			log.Printf("Purged %d deleted chirps", len(purged))
+		}
+		<-ticker.C
+	}
+}
+
+// DeletedChirp is the admin view of a deleted chirp, with the content
+// chirpFromDB hides and who deleted it when
+type DeletedChirp struct {
+	ID          int          `json:"id"`
+	Body        string       `json:"body"`
+	AuthorID    int          `json:"author_id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	ParentID    int          `json:"parent_id,omitempty"`
+	Hashtags    []string     `json:"hashtags,omitempty"`
+	Mentions    []int        `json:"mentions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	DeletedAt   time.Time    `json:"deleted_at"`
+	DeletedBy   int          `json:"deleted_by"`
+}
+
+func deletedChirpFromDB(chirp types.Chirp) DeletedChirp {
+	resp := DeletedChirp{
+		ID:          chirp.Id,
+		Body:        chirp.Body,
+		AuthorID:    chirp.AuthorID,
+		CreatedAt:   chirp.CreatedAt,
+		UpdatedAt:   chirp.UpdatedAt,
+		ParentID:    chirp.ParentID,
+		Hashtags:    chirp.Hashtags,
+		Mentions:    chirp.Mentions,
+		Attachments: attachmentsFromDB(chirp.Attachments),
+		DeletedBy:   chirp.DeletedBy,
+	}
+	if chirp.DeletedAt != nil {
+		resp.DeletedAt = *chirp.DeletedAt
+	}
+	return resp
+}
+
+// handlerDeletedChirps lists the deleted chirps that can still be
+// restored, most recently deleted first, a page at a time
+func (cfg *apiConfig) handlerDeletedChirps(w http.ResponseWriter, r *http.Request) {
+	type pageResponse struct {
+		Chirps     []DeletedChirp `json:"chirps"`
+		NextCursor string         `json:"next_cursor,omitempty"`
+	}
+
+	p, paginated, err := parsePage(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !paginated {
+		p.limit = defaultPageSize
+	}
+	q := database.ChirpQuery{
+		Deleted: true,
+		OrderBy: database.OrderByDeletedAt,
+		Sort:    "desc",
+		After:   p.after,
+		// One extra chirp tells whether there is a next page
+		Limit: p.limit + 1,
+	}
+	chirps, err := cfg.db.ListChirps(q)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting chirps: %s", err))
+		return
+	}
+
+	resp := pageResponse{Chirps: make([]DeletedChirp, 0, len(chirps))}
+	if len(chirps) > p.limit {
+		chirps = chirps[:p.limit]
+		resp.NextCursor = encodeCursor(q.CursorFor(chirps[p.limit-1]))
+	}
+	for _, chirp := range chirps {
+		resp.Chirps = append(resp.Chirps, deletedChirpFromDB(chirp))
+	}
+	setNextLink(w, r, resp.NextCursor)
+	respondWithJson(w, http.StatusOK, resp)
+}
+
+// handlerRestoreChirp undoes the deletion of a chirp that wasn't purged
+// yet
+func (cfg *apiConfig) handlerRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+	chirp, err := cfg.db.RestoreChirp(chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Deleted chirp not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't restore the chirp: %s", err))
+		}
+		return
+	}
+	resp, err := cfg.publicChirp(chirp, viewerID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't get the chirp: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, resp)
+}
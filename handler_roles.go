@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/erwaen/Chirpy/auth"
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// makeAdmin gives the user with email the admin role, to set up the
+// first admin from the command line
+func makeAdmin(db database.Store, email string) error {
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("couldn't find admin %s: %v", email, err)
+	}
+	if user.Role == types.RoleAdmin {
+		// Setting the role again would log them out
+		return nil
+	}
+	_, err = db.SetUserRole(user.Id, types.RoleAdmin)
+	return err
+}
+
+// handlerSetUserRole changes the role of the user in the path. Their
+// access tokens stop working and the next refresh gives them the scopes
+// of the new role.
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+	type response struct {
+		User
+		Role string `json:"role"`
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID parameter")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "role must be user, moderator or admin")
+		return
+	}
+
+	user, err := cfg.db.SetUserRole(userID, params.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't set the role")
+		}
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{
+		User: User{
+			ID:          user.Id,
+			Email:       user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+		},
+		Role: user.Role,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/erwaen/Chirpy/database"
+)
+
+// handlerSearchChirps runs a full-text search over chirp bodies. q holds
+// words and "quoted phrases", author_id and limit are optional.
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := database.SearchQuery{
+		Text:  query.Get("q"),
+		Limit: defaultPageSize,
+	}
+	if s := query.Get("author_id"); s != "" {
+		authorID, err := strconv.Atoi(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author_id parameter")
+			return
+		}
+		q.AuthorID = authorID
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		q.Limit = min(limit, maxPageSize)
+	}
+
+	chirps, err := cfg.db.SearchChirps(q)
+	if err != nil {
+		if errors.Is(err, database.ErrEmptySearch) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+		} else {
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error searching chirps: %s", err))
+		}
+		return
+	}
+	resp, err := cfg.publicChirps(chirps, viewerID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error searching chirps: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, resp)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// Session is a device a user is logged in on: the current refresh token
+// of a login. ID is the token family.
+type Session struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+}
+
+func sessionsFromDB(tokens []types.RefreshToken) []Session {
+	resp := make([]Session, 0, len(tokens))
+	for _, rf := range tokens {
+		resp = append(resp, Session{
+			ID:         rf.FamilyID,
+			CreatedAt:  rf.CreatedAt,
+			LastUsedAt: rf.LastUsedAt,
+			ExpiresAt:  rf.ExpireAt,
+			UserAgent:  rf.UserAgent,
+			IP:         rf.IP,
+		})
+	}
+	return resp
+}
+
+// parseTrustedProxies reads TRUSTED_PROXIES, a comma separated list of
+// the IPs or CIDR ranges of the proxies in front of the server
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if ip := net.ParseIP(field); ip != nil {
+			// A single address is a range of one
+			field += "/128"
+			if ip.To4() != nil {
+				field = ip.String() + "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (cfg *apiConfig) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range cfg.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address of the connection a request came through
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// clientIP is the address of the client a session is shown with. When
+// the request comes from a trusted proxy it is the last address of
+// X-Forwarded-For that isn't one of the trusted proxies, since clients
+// can put anything in front of it.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !cfg.trustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !cfg.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// handlerSessions lists the sessions of the user, the most recently used
+// first
+func (cfg *apiConfig) handlerSessions(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	sessions, err := cfg.db.GetSessions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error getting sessions: %s", err))
+		return
+	}
+	respondWithJson(w, http.StatusOK, sessionsFromDB(sessions))
+}
+
+// handlerRevokeSession logs the user out of one session. Its access
+// tokens stay valid until they expire.
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	err := cfg.db.RevokeSession(userID, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Session not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the session")
+		}
+		return
+	}
+	respondWithoutJson(w, http.StatusNoContent)
+}
+
+// handlerRevokeAllSessions logs the user out everywhere, the access
+// tokens already issued included
+func (cfg *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	_, err := cfg.db.RevokeAllSessions(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+		} else {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the sessions")
+		}
+		return
+	}
+	respondWithoutJson(w, http.StatusNoContent)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/pubsub"
+	"github.com/erwaen/Chirpy/types"
+)
+
+const (
+	eventChirpCreated = "chirp_created"
+	eventChirpDeleted = "chirp_deleted"
+	eventNotification = "notification"
+
+	// streamHistory is how many events a reconnecting client can catch up
+	// on with Last-Event-ID
+	streamHistory = 1000
+	// streamBuffer is how many events a slow client can fall behind
+	// before it is disconnected and has to resume
+	streamBuffer    = 64
+	streamKeepAlive = 15 * time.Second
+)
+
+// chirpTopic is the hub topic of the chirps of an author
+func chirpTopic(authorID int) string {
+	return "chirps:" + strconv.Itoa(authorID)
+}
+
+// notificationTopic is the hub topic of the notifications of a user
+func notificationTopic(userID int) string {
+	return "notifications:" + strconv.Itoa(userID)
+}
+
+// storeEvents wraps a store so every chirp becoming visible or being
+// deleted, and every notification it causes, is published on the hub,
+// whichever handler or job caused it. Drafts and scheduled chirps are
+// only announced once published.
+type storeEvents struct {
+	database.Store
+	hub *pubsub.Hub
+}
+
+// deletedChirp is the payload of chirp_deleted events
+type deletedChirp struct {
+	ID       int `json:"id"`
+	AuthorID int `json:"author_id"`
+}
+
+func (s storeEvents) publish(eventType string, authorID int, data any) {
+	_, err := s.hub.Publish(chirpTopic(authorID), eventType, data)
+	if err != nil {
+		log.Printf("Error publishing %s event: %s", eventType, err)
+	}
+}
+
+func (s storeEvents) created(chirp types.Chirp, since time.Time) {
+	if chirp.Published() {
+		s.publish(eventChirpCreated, chirp.AuthorID, chirpFromDB(chirp))
+		s.notified(chirp, since)
+	}
+}
+
+// notified publishes the notifications the mentions of chirp caused
+// since the write started. Users mentioned before an edit were already
+// notified and have nothing newer.
+func (s storeEvents) notified(chirp types.Chirp, since time.Time) {
+	// The SQL backend keeps milliseconds
+	since = since.Truncate(time.Millisecond)
+	for _, userID := range chirp.Mentions {
+		notifications, err := s.Store.GetNotifications(userID)
+		if err != nil {
+			log.Printf("Error getting notifications of user %d: %s", userID, err)
+			continue
+		}
+		for _, notification := range notifications {
+			if notification.ChirpID == chirp.Id && !notification.CreatedAt.Before(since) {
+				_, err := s.hub.Publish(notificationTopic(userID), eventNotification, notification)
+				if err != nil {
+					log.Printf("Error publishing %s event: %s", eventNotification, err)
+				}
+			}
+		}
+	}
+}
+
+func (s storeEvents) CreateChirp(chirp types.Chirp) (types.Chirp, error) {
+	start := time.Now()
+	chirp, err := s.Store.CreateChirp(chirp)
+	if err == nil {
+		s.created(chirp, start)
+	}
+	return chirp, err
+}
+
+func (s storeEvents) UpdateChirp(chirp types.Chirp) (types.Chirp, error) {
+	start := time.Now()
+	chirp, err := s.Store.UpdateChirp(chirp)
+	if err == nil && chirp.Published() {
+		s.notified(chirp, start)
+	}
+	return chirp, err
+}
+
+func (s storeEvents) PublishChirp(id int) (types.Chirp, error) {
+	start := time.Now()
+	chirp, err := s.Store.PublishChirp(id)
+	if err == nil {
+		s.created(chirp, start)
+	}
+	return chirp, err
+}
+
+func (s storeEvents) PublishDueChirps(now time.Time) ([]types.Chirp, error) {
+	start := time.Now()
+	chirps, err := s.Store.PublishDueChirps(now)
+	for _, chirp := range chirps {
+		s.created(chirp, start)
+	}
+	return chirps, err
+}
+
+func (s storeEvents) RestoreChirp(id int) (types.Chirp, error) {
+	chirp, err := s.Store.RestoreChirp(id)
+	if err == nil && chirp.Published() {
+		s.publish(eventChirpCreated, chirp.AuthorID, chirpFromDB(chirp))
+	}
+	return chirp, err
+}
+
+func (s storeEvents) DeleteChirp(id, deletedBy int) (types.Chirp, error) {
+	chirp, err := s.Store.DeleteChirp(id, deletedBy)
+	if err == nil && chirp.Published() {
+		s.publish(eventChirpDeleted, chirp.AuthorID, deletedChirp{ID: chirp.Id, AuthorID: chirp.AuthorID})
+	}
+	return chirp, err
+}
+
+// handlerChirpStream pushes chirp_created and chirp_deleted events as
+// Server-Sent Events, optionally only those of author_id. Clients
+// resuming with Last-Event-ID get the events they missed first, as long
+// as the hub still remembers them.
+func (cfg *apiConfig) handlerChirpStream(w http.ResponseWriter, r *http.Request) {
+	authorID := 0
+	if s := r.URL.Query().Get("author_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author_id parameter")
+			return
+		}
+		authorID = id
+	}
+	filter := func(event pubsub.Event) bool {
+		if authorID != 0 {
+			return event.Topic == chirpTopic(authorID)
+		}
+		return strings.HasPrefix(event.Topic, "chirps:")
+	}
+
+	var lastID uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID header")
+			return
+		}
+		lastID = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Error starting chirp stream: %s", err)
+		return
+	}
+
+	sub := cfg.hub.Subscribe(filter, lastID)
+	defer sub.Cancel()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Too far behind, the client reconnects with Last-Event-ID
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erwaen/Chirpy/pubsub"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	wsTopicChirps        = "chirps"
+	wsTopicNotifications = "notifications"
+	wsTopicStock         = "stock"
+
+	eventItemStock = "item_stock"
+
+	defaultStockPollInterval = 30 * time.Second
+
+	wsWriteTimeout = 10 * time.Second
+	// wsReadLimit caps the size of the messages clients send, they are
+	// only subscriptions
+	wsReadLimit = 4096
+)
+
+// wsPingInterval is how often connections are pinged and their user is
+// checked again
+var wsPingInterval = 30 * time.Second
+
+// parseStockPollInterval reads STOCK_POLL_INTERVAL, a Go duration like
+// "30s"
+func parseStockPollInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultStockPollInterval, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid stock poll interval %q", s)
+	}
+	return interval, nil
+}
+
+// parseWSOrigins reads WS_ORIGINS, a comma separated list of the hosts
+// other than the API's own whose pages may open WebSockets, like
+// "anniagumi.lat" or "*.anniagumi.lat"
+func parseWSOrigins(s string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid WebSocket origin %q", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// runStockPoller reads the item stock from Turso every interval and
+// publishes the items whose stock changed since the previous read. It
+// never returns.
+func (cfg *apiConfig) runStockPoller(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var stock map[int]int
+	for {
+		items, err := cfg.tursoDB.GetItemsStock()
+		if err != nil {
+			log.Printf("Error polling item stock: %s", err)
+		} else {
+			next := make(map[int]int, len(items))
+			for _, item := range items {
+				next[item.ID] = item.Stock
+				// The first read only sets the baseline
+				if prev, ok := stock[item.ID]; stock != nil && (!ok || prev != item.Stock) {
+					if _, err := cfg.hub.Publish(wsTopicStock, eventItemStock, item); err != nil {
+						log.Printf("Error publishing %s event: %s", eventItemStock, err)
+					}
+				}
+			}
+			stock = next
+		}
+		<-ticker.C
+	}
+}
+
+// wsMessage is what clients send: {"type": "subscribe", "topic": "chirps"}
+type wsMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+}
+
+// wsReply is what the server sends, either an event of a subscribed
+// topic, an acknowledgement of a (un)subscription or an error
+type wsReply struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	ID    uint64          `json:"id,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// wsTopics is the set of topics a connection is subscribed to
+type wsTopics struct {
+	mux    sync.Mutex
+	userID int
+	topics map[string]bool
+}
+
+// match maps an event of the hub to the topic the connection subscribed
+// to, users only get their own notifications
+func (t *wsTopics) match(event pubsub.Event) (string, bool) {
+	topic := event.Topic
+	switch {
+	case strings.HasPrefix(topic, "chirps:"):
+		topic = wsTopicChirps
+	case topic == notificationTopic(t.userID):
+		topic = wsTopicNotifications
+	case topic != wsTopicStock:
+		return "", false
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return topic, t.topics[topic]
+}
+
+func (t *wsTopics) set(topic string, subscribed bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.topics[topic] = subscribed
+}
+
+// wsAccessToken passes the access_token query parameter on as the
+// bearer token when there is no Authorization header: browsers can't set
+// headers on WebSocket requests
+func wsAccessToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if r.Header.Get("Authorization") == "" && token != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// handlerWebSocket upgrades to a WebSocket on which the client
+// subscribes to the chirps, notifications and stock topics
+func (cfg *apiConfig) handlerWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID := authUser(r).Id
+
+	// The token can come from the query string, which any page can put
+	// in a URL, so browsers may only connect from the allowed origins
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: cfg.wsOrigins})
+	if err != nil {
+		log.Printf("Error accepting websocket: %s", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsReadLimit)
+
+	topics := &wsTopics{userID: userID, topics: map[string]bool{}}
+	sub := cfg.hub.Subscribe(func(event pubsub.Event) bool {
+		_, ok := topics.match(event)
+		return ok
+	}, 0)
+	defer sub.Cancel()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// Replies to subscriptions go through the same writer as the events
+	// so writes never overlap
+	replies := make(chan wsReply, 1)
+	go func() {
+		defer cancel()
+		for {
+			var msg wsMessage
+			if err := wsjson.Read(ctx, conn, &msg); err != nil {
+				return
+			}
+			reply := wsReply{Type: msg.Type, Topic: msg.Topic}
+			switch {
+			case msg.Type != "subscribe" && msg.Type != "unsubscribe":
+				reply = wsReply{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type)}
+			case msg.Topic != wsTopicChirps && msg.Topic != wsTopicNotifications && msg.Topic != wsTopicStock:
+				reply = wsReply{Type: "error", Error: fmt.Sprintf("unknown topic %q", msg.Topic)}
+			default:
+				topics.set(msg.Topic, msg.Type == "subscribe")
+			}
+			select {
+			case replies <- reply:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Ping waits for the pong, which the reading goroutine processes, so
+	// it runs on its own to not hold up the events meanwhile
+	go func() {
+		defer cancel()
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ping.C:
+			}
+			if !cfg.wsAuthorized(r) {
+				conn.Close(websocket.StatusPolicyViolation, "session revoked")
+				return
+			}
+			pingCtx, cancelPing := context.WithTimeout(ctx, wsWriteTimeout)
+			err := conn.Ping(pingCtx)
+			cancelPing()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var reply wsReply
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				conn.Close(websocket.StatusTryAgainLater, "too slow to keep up")
+				return
+			}
+			topic, subscribed := topics.match(event)
+			if !subscribed {
+				// Unsubscribed while the event was queued
+				continue
+			}
+			reply = wsReply{Type: "event", Topic: topic, ID: event.ID, Event: event.Type, Data: event.Data}
+		case reply = <-replies:
+		}
+		writeCtx, cancelWrite := context.WithTimeout(ctx, wsWriteTimeout)
+		err := wsjson.Write(writeCtx, conn, reply)
+		cancelWrite()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wsAuthorized checks again that the user of a connection may use it:
+// they weren't suspended and didn't log out everywhere since the
+// connection was opened
+func (cfg *apiConfig) wsAuthorized(r *http.Request) bool {
+	user, err := cfg.db.GetUserByID(authUser(r).Id)
+	if err != nil {
+		return false
+	}
+	return !user.Suspended && user.TokenVersion == claimsFromContext(r.Context()).TokenVersion
+}
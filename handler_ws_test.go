@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erwaen/Chirpy/auth"
+	"github.com/erwaen/Chirpy/database"
+	"github.com/erwaen/Chirpy/pubsub"
+	"github.com/erwaen/Chirpy/types"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// newWSTestServer serves /api/ws like main does, on a fresh JSON
+// database, and returns a user with an access token
+func newWSTestServer(t *testing.T) (*apiConfig, *httptest.Server, types.User, string) {
+	t.Helper()
+	db, err := database.NewDBWithOptions(filepath.Join(t.TempDir(), "database.json"), database.Options{})
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	hub := pubsub.NewHub(streamHistory, streamBuffer)
+	cfg := &apiConfig{
+		db:   storeEvents{Store: db, hub: hub},
+		keys: auth.NewHMACKeyring("secret"),
+		hub:  hub,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/ws", wsAccessToken(cfg.requireAuth(cfg.handlerWebSocket)))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	user, err := db.CreateUser("walter@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := auth.MakeJWT(user, cfg.keys, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return cfg, srv, user, token
+}
+
+func wsURL(srv *httptest.Server, token string) string {
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/ws"
+	if token != "" {
+		u += "?access_token=" + token
+	}
+	return u
+}
+
+func TestWebSocketRequiresToken(t *testing.T) {
+	_, srv, _, _ := newWSTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for name, token := range map[string]string{"missing": "", "invalid": "junk"} {
+		conn, resp, err := websocket.Dial(ctx, wsURL(srv, token), nil)
+		if err == nil {
+			conn.CloseNow()
+			t.Fatalf("%s token: dial succeeded", name)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s token: got response %v, want 401", name, resp)
+		}
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	cfg, srv, user, token := newWSTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, wsURL(srv, token), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	send := func(msgType string) {
+		t.Helper()
+		if err := wsjson.Write(ctx, conn, wsMessage{Type: msgType, Topic: wsTopicChirps}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		var ack wsReply
+		if err := wsjson.Read(ctx, conn, &ack); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if ack.Type != msgType || ack.Topic != wsTopicChirps {
+			t.Fatalf("got %+v, want the %s acknowledgement", ack, msgType)
+		}
+	}
+	chirp := func(body string) types.Chirp {
+		t.Helper()
+		chirp, err := cfg.db.CreateChirp(types.Chirp{Body: body, AuthorID: user.Id})
+		if err != nil {
+			t.Fatalf("CreateChirp: %v", err)
+		}
+		return chirp
+	}
+	// nextChirpEvent reads the next message, which must be a chirp event,
+	// and returns the ID of its chirp
+	nextChirpEvent := func() int {
+		t.Helper()
+		var reply wsReply
+		if err := wsjson.Read(ctx, conn, &reply); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if reply.Type != "event" || reply.Topic != wsTopicChirps || reply.Event != eventChirpCreated {
+			t.Fatalf("got %+v, want a %s event", reply, eventChirpCreated)
+		}
+		var data Chirp
+		if err := json.Unmarshal(reply.Data, &data); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		return data.ID
+	}
+
+	send("subscribe")
+	first := chirp("first")
+	if got := nextChirpEvent(); got != first.Id {
+		t.Fatalf("got an event for chirp %d, want %d", got, first.Id)
+	}
+
+	send("unsubscribe")
+	missed := chirp("missed")
+	send("subscribe")
+	last := chirp("last")
+	if got := nextChirpEvent(); got != last.Id {
+		t.Fatalf("got an event for chirp %d, want only %d after unsubscribing from %d", got, last.Id, missed.Id)
+	}
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	cfg, srv, _, token := newWSTestServer(t)
+	cfg.wsOrigins = []string{"app.example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for origin, allowed := range map[string]bool{
+		"":                        true,
+		"https://app.example.com": true,
+		"https://evil.example":    false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.Dial(ctx, wsURL(srv, token), &websocket.DialOptions{HTTPHeader: header})
+		if err == nil {
+			conn.CloseNow()
+		}
+		if allowed != (err == nil) {
+			t.Errorf("origin %q: got error %v, want allowed %v", origin, err, allowed)
+		}
+		if !allowed && (resp == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Errorf("origin %q: got response %v, want 403", origin, resp)
+		}
+	}
+}
+
+// TestWebSocketClosedWhenRevoked checks that open connections are
+// closed at the next ping once their user can't use the token anymore
+func TestWebSocketClosedWhenRevoked(t *testing.T) {
+	defer func(interval time.Duration) { wsPingInterval = interval }(wsPingInterval)
+	wsPingInterval = 20 * time.Millisecond
+
+	revocations := map[string]func(db database.Store, userID int) error{
+		"log out everywhere": func(db database.Store, userID int) error {
+			_, err := db.RevokeAllSessions(userID)
+			return err
+		},
+		"suspension": func(db database.Store, userID int) error {
+			_, err := db.SuspendUser(userID)
+			return err
+		},
+	}
+	for name, revoke := range revocations {
+		t.Run(name, func(t *testing.T) {
+			cfg, srv, user, token := newWSTestServer(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, _, err := websocket.Dial(ctx, wsURL(srv, token), nil)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.CloseNow()
+			if err := revoke(cfg.db, user.Id); err != nil {
+				t.Fatal(err)
+			}
+			var reply wsReply
+			err = wsjson.Read(ctx, conn, &reply)
+			if status := websocket.CloseStatus(err); status != websocket.StatusPolicyViolation {
+				t.Errorf("got %+v, %v, want the connection closed with status %v", reply, err, websocket.StatusPolicyViolation)
+			}
+		})
+	}
+}
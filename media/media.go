@@ -0,0 +1,109 @@
+// Package media validates uploaded images and makes their thumbnails
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// ThumbnailSize is the maximum width and height of a thumbnail
+	ThumbnailSize = 320
+	// MaxPixels caps the decoded size of an image, so a small file
+	// can't expand to gigabytes of memory
+	MaxPixels = 40_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("Only PNG, JPEG and GIF images are supported")
+	ErrTooManyPixels   = errors.New("Image dimensions are too large")
+)
+
+// extensions maps the supported content types to file extensions
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Image is a validated upload
+type Image struct {
+	// ContentType is sniffed from the data, the client's claim is
+	// ignored
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+	// Thumbnail fits in ThumbnailSize x ThumbnailSize. It is a JPEG for
+	// JPEG images and a PNG otherwise, to keep transparency.
+	Thumbnail            []byte
+	ThumbnailContentType string
+	ThumbnailExt         string
+}
+
+// Process checks that data is a supported image and renders its
+// thumbnail
+func Process(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return Image{}, ErrUnsupportedType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return Image{}, ErrTooManyPixels
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+
+	img := Image{
+		ContentType: contentType,
+		Ext:         ext,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}
+	thumb := thumbnail(src)
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		img.ThumbnailContentType, img.ThumbnailExt = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(&buf, thumb)
+		img.ThumbnailContentType, img.ThumbnailExt = "image/png", ".png"
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	img.Thumbnail = buf.Bytes()
+	return img, nil
+}
+
+// thumbnail scales src down to fit ThumbnailSize, keeping its aspect
+// ratio. Smaller images are kept as they are.
+func thumbnail(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= ThumbnailSize && h <= ThumbnailSize {
+		return src
+	}
+	if w >= h {
+		w, h = ThumbnailSize, max(1, h*ThumbnailSize/w)
+	} else {
+		w, h = max(1, w*ThumbnailSize/h), ThumbnailSize
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
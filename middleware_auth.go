@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/erwaen/Chirpy/auth"
+	"github.com/erwaen/Chirpy/types"
+)
+
+// errNoToken is returned by authenticate for requests without a bearer
+// token
+var errNoToken = errors.New("no bearer token")
+
+// errSuspended is returned by authenticate for users who were suspended
+// after their access token was issued
+var errSuspended = errors.New("account suspended")
+
+type authKey struct{}
+
+// authInfo is what the auth middlewares store in the request context
+type authInfo struct {
+	user   types.User
+	claims *auth.Claims
+}
+
+// authenticate validates the access token of r and loads its user
+func (cfg *apiConfig) authenticate(r *http.Request) (authInfo, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return authInfo{}, errNoToken
+	}
+	var user types.User
+	claims, err := auth.ParseJWT(token, cfg.keys, func(userID int) (int, error) {
+		var err error
+		user, err = cfg.db.GetUserByID(userID)
+		return user.TokenVersion, err
+	})
+	if err != nil {
+		return authInfo{}, err
+	}
+	if user.Suspended {
+		return authInfo{}, errSuspended
+	}
+	return authInfo{user: user, claims: claims}, nil
+}
+
+// requireAuth lets through only requests with a valid access token of a
+// user who isn't suspended. The handler gets the user with authUser.
+func (cfg *apiConfig) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), authKey{}, info)))
+	}
+}
+
+// optionalAuth is requireAuth for endpoints anyone can read but that
+// show more to logged in users. Requests without a usable token go
+// through anonymously.
+func (cfg *apiConfig) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := cfg.authenticate(r)
+		if err == nil {
+			r = r.WithContext(context.WithValue(r.Context(), authKey{}, info))
+		}
+		next(w, r)
+	}
+}
+
+// requireScope returns the middleware letting through only requests
+// whose access token carries every one of scopes, on top of what
+// requireAuth checks. The handler gets the claims of the token with
+// claimsFromContext.
+func (cfg *apiConfig) requireScope(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+			claims := claimsFromContext(r.Context())
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					respondWithError(w, http.StatusForbidden, fmt.Sprintf("Missing scope %s", scope))
+					return
+				}
+			}
+			next(w, r)
+		})
+	}
+}
+
+// respondWithAuthError answers a request authenticate rejected: 401 when
+// the token is missing or invalid, 403 when it is valid but the user
+// can't use it
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSuspended):
+		respondWithError(w, http.StatusForbidden, "Your account is suspended")
+	case errors.Is(err, errNoToken):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+	}
+}
+
+// authUser returns the user stored by the auth middlewares, the zero
+// User for anonymous requests
+func authUser(r *http.Request) types.User {
+	info, _ := r.Context().Value(authKey{}).(authInfo)
+	return info.user
+}
+
+// viewerID is the ID of the logged in user of an optionalAuth request,
+// 0 for anonymous ones
+func viewerID(r *http.Request) int {
+	return authUser(r).Id
+}
+
+// claimsFromContext returns the claims of the access token stored by the
+// auth middlewares, nil for anonymous requests
+func claimsFromContext(ctx context.Context) *auth.Claims {
+	info, _ := ctx.Value(authKey{}).(authInfo)
+	return info.claims
+}
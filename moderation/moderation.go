@@ -0,0 +1,234 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Action is what happens to a chirp containing a rule's word
+type Action string
+
+const (
+	// ActionMask replaces the word by asterisks
+	ActionMask Action = "mask"
+	// ActionReject refuses the chirp
+	ActionReject Action = "reject"
+	// ActionFlag accepts the chirp but marks it for review
+	ActionFlag Action = "flag"
+)
+
+const mask = "****"
+
+var (
+	ErrTooLong  = errors.New("Chirp is too long")
+	ErrRejected = errors.New("Chirp contains forbidden words")
+)
+
+type Rule struct {
+	Word   string `json:"word"`
+	Action Action `json:"action"`
+}
+
+// Config is the content of a rules file
+type Config struct {
+	// MaxLength is the maximum number of characters (not bytes) of a
+	// chirp
+	MaxLength int    `json:"max_length"`
+	Rules     []Rule `json:"rules"`
+}
+
+// DefaultConfig is used when no rules file is configured
+func DefaultConfig() Config {
+	return Config{
+		MaxLength: 140,
+		Rules: []Rule{
+			{Word: "kerfuffle", Action: ActionMask},
+			{Word: "sharbert", Action: ActionMask},
+			{Word: "fornax", Action: ActionMask},
+		},
+	}
+}
+
+// Source loads the moderation config, e.g. from a file or a database
+type Source interface {
+	Load() (Config, error)
+}
+
+// FileSource reads the config from a JSON file. An empty path means the
+// default config.
+type FileSource struct {
+	Path string
+}
+
+func (f FileSource) Load() (Config, error) {
+	if f.Path == "" {
+		return DefaultConfig(), nil
+	}
+	dat, err := os.ReadFile(f.Path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{}
+	if err := json.Unmarshal(dat, &cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid moderation rules in %s: %v", f.Path, err)
+	}
+	if cfg.MaxLength == 0 {
+		cfg.MaxLength = DefaultConfig().MaxLength
+	}
+	return cfg, nil
+}
+
+// Result is the outcome of checking a chirp that was not rejected
+type Result struct {
+	// Body is the chirp in NFKC form with masked words replaced
+	Body string
+	// Flagged is true when a flag rule matched
+	Flagged bool
+	// Matches are the rules that matched, in rule order
+	Matches []Rule
+}
+
+// Moderator checks chirps against the current rules. Rules can be
+// reloaded from the source while requests are being served.
+type Moderator struct {
+	source Source
+	mux    sync.RWMutex
+	cfg    Config
+	rules  map[string]Rule
+}
+
+func NewModerator(source Source) (*Moderator, error) {
+	m := &Moderator{source: source}
+	err := m.Reload()
+	return m, err
+}
+
+// Reload reads the rules from the source again. The current rules are
+// kept if loading fails.
+func (m *Moderator) Reload() error {
+	cfg, err := m.source.Load()
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]Rule, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		switch rule.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return fmt.Errorf("unknown action %q for word %q", rule.Action, rule.Word)
+		}
+		word := normalize(rule.Word)
+		if word == "" {
+			continue
+		}
+		// Chirps are checked word by word, so a rule spanning several
+		// words could never match
+		if words := splitWords(word); len(words) != 1 || words[0] != (span{0, len(word)}) {
+			return fmt.Errorf("rule %q must be a single word", rule.Word)
+		}
+		// With duplicated words the strictest action wins
+		if prev, ok := rules[word]; ok && severity(prev.Action) >= severity(rule.Action) {
+			continue
+		}
+		rules[word] = rule
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.cfg = cfg
+	m.rules = rules
+	return nil
+}
+
+// Config returns the rules in use
+func (m *Moderator) Config() Config {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.cfg
+}
+
+// Check validates a chirp body. The body is put in Unicode NFKC form
+// first, so fullwidth, ligature or decomposed spellings of a word are
+// the word itself. Words are then compared ignoring case and the
+// punctuation around them, so "Kerfuffle!" matches "kerfuffle".
+func (m *Moderator) Check(body string) (Result, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	body = norm.NFKC.String(body)
+	if utf8.RuneCountInString(body) > m.cfg.MaxLength {
+		return Result{}, ErrTooLong
+	}
+
+	result := Result{}
+	var cleaned strings.Builder
+	last := 0
+	for _, w := range splitWords(body) {
+		rule, ok := m.rules[normalize(body[w.start:w.end])]
+		if !ok {
+			continue
+		}
+		result.Matches = append(result.Matches, rule)
+		switch rule.Action {
+		case ActionReject:
+			return Result{}, ErrRejected
+		case ActionFlag:
+			result.Flagged = true
+		case ActionMask:
+			cleaned.WriteString(body[last:w.start])
+			cleaned.WriteString(mask)
+			last = w.end
+		}
+	}
+	cleaned.WriteString(body[last:])
+	result.Body = cleaned.String()
+	return result, nil
+}
+
+type span struct {
+	start, end int
+}
+
+// splitWords returns the byte offsets of the runs of letters and digits
+// in s
+func splitWords(s string) []span {
+	var words []span
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			words = append(words, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(s)})
+	}
+	return words
+}
+
+func normalize(word string) string {
+	return strings.ToLower(strings.TrimFunc(norm.NFKC.String(word), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}))
+}
+
+func severity(a Action) int {
+	switch a {
+	case ActionReject:
+		return 3
+	case ActionFlag:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,95 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+)
+
+// staticSource serves a fixed config
+type staticSource Config
+
+func (s staticSource) Load() (Config, error) {
+	return Config(s), nil
+}
+
+func newTestModerator(t *testing.T, maxLength int, rules ...Rule) *Moderator {
+	t.Helper()
+	m, err := NewModerator(staticSource{MaxLength: maxLength, Rules: rules})
+	if err != nil {
+		t.Fatalf("NewModerator: %v", err)
+	}
+	return m
+}
+
+func TestCheckActions(t *testing.T) {
+	m := newTestModerator(t, 140,
+		Rule{Word: "kerfuffle", Action: ActionMask},
+		Rule{Word: "sharbert", Action: ActionReject},
+		Rule{Word: "café", Action: ActionReject},
+		Rule{Word: "fornax", Action: ActionFlag},
+	)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		flagged bool
+		err     error
+	}{
+		{name: "clean", body: "hello world", want: "hello world"},
+		{name: "mask", body: "What a Kerfuffle!", want: "What a ****!"},
+		{name: "mask fullwidth", body: "what a ｋｅｒｆｕｆｆｌｅ", want: "what a ****"},
+		{name: "reject", body: "I like SHARBERT.", err: ErrRejected},
+		{name: "reject circled", body: "I like ⓢⓗⓐⓡⓑⓔⓡⓣ", err: ErrRejected},
+		{name: "reject decomposed", body: "meet me at the cafe\u0301", err: ErrRejected},
+		{name: "flag", body: "fornax, again", want: "fornax, again", flagged: true},
+		{name: "inside a word", body: "kerfuffles everywhere", want: "kerfuffles everywhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := m.Check(tt.body)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if result.Body != tt.want || result.Flagged != tt.flagged {
+				t.Errorf("got %q flagged %v, want %q flagged %v", result.Body, result.Flagged, tt.want, tt.flagged)
+			}
+		})
+	}
+}
+
+// TestCheckLength checks that the limit counts characters, not bytes
+func TestCheckLength(t *testing.T) {
+	m := newTestModerator(t, 5)
+
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{body: "h\u00e9llo"},
+		{body: "日本語です"},
+		// Composed by NFKC into five characters
+		{body: "he\u0301llo"},
+		{body: "héllo!", err: ErrTooLong},
+		{body: "日本語ですね", err: ErrTooLong},
+	}
+	for _, tt := range tests {
+		if _, err := m.Check(tt.body); !errors.Is(err, tt.err) {
+			t.Errorf("Check(%q): got error %v, want %v", tt.body, err, tt.err)
+		}
+	}
+}
+
+func TestReloadRejectsInvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{Word: "two words", Action: ActionMask},
+		{Word: "kerfuffle", Action: "ban"},
+	} {
+		if _, err := NewModerator(staticSource{MaxLength: 140, Rules: []Rule{rule}}); err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
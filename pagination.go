@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erwaen/Chirpy/database"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor turns the position of the last chirp of a page into the
+// opaque cursor handed to clients
+func encodeCursor(c database.ChirpCursor) string {
+	raw := fmt.Sprintf("id:%d", c.ID)
+	if !c.Time.IsZero() {
+		raw = fmt.Sprintf("t:%d:%d", c.Time.UnixNano(), c.ID)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (database.ChirpCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return database.ChirpCursor{}, errInvalidCursor
+	}
+	var c database.ChirpCursor
+	if idString, ok := strings.CutPrefix(string(dat), "id:"); ok {
+		c.ID, err = strconv.Atoi(idString)
+	} else if rest, ok := strings.CutPrefix(string(dat), "t:"); ok {
+		nanosString, idString, _ := strings.Cut(rest, ":")
+		var nanos int64
+		nanos, err = strconv.ParseInt(nanosString, 10, 64)
+		if err == nil {
+			c.Time = time.Unix(0, nanos).UTC()
+			c.ID, err = strconv.Atoi(idString)
+		}
+	} else {
+		return database.ChirpCursor{}, errInvalidCursor
+	}
+	if err != nil || c.ID <= 0 {
+		return database.ChirpCursor{}, errInvalidCursor
+	}
+	return c, nil
+}
+
+// parseChirpSort maps the sort query parameter to a store ordering:
+// "asc" and "desc" order by ID, "created_at_asc", "created_at_desc",
+// "updated_at_asc" and "updated_at_desc" by time
+func parseChirpSort(sort string) (orderBy string, direction string) {
+	for _, field := range []string{database.OrderByCreatedAt, database.OrderByUpdatedAt} {
+		if dir, ok := strings.CutPrefix(sort, field+"_"); ok {
+			return field, dir
+		}
+	}
+	return database.OrderByID, sort
+}
+
+// page holds the limit and cursor query parameters of a paginated
+// request
+type page struct {
+	limit int
+	after database.ChirpCursor
+}
+
+// parsePage reads limit and cursor from the query string. ok is false
+// when the client asked for neither, so handlers can keep returning the
+// full list to older clients.
+func parsePage(r *http.Request) (p page, ok bool, err error) {
+	query := r.URL.Query()
+	limitString := query.Get("limit")
+	cursor := query.Get("cursor")
+	if limitString == "" && cursor == "" {
+		return page{}, false, nil
+	}
+
+	p.limit = defaultPageSize
+	if limitString != "" {
+		p.limit, err = strconv.Atoi(limitString)
+		if err != nil || p.limit <= 0 {
+			return page{}, true, errors.New("invalid limit")
+		}
+		p.limit = min(p.limit, maxPageSize)
+	}
+	if cursor != "" {
+		p.after, err = decodeCursor(cursor)
+		if err != nil {
+			return page{}, true, err
+		}
+	}
+	return p, true, nil
+}
+
+// setNextLink adds a Link header pointing at the page after nextCursor,
+// keeping every other query parameter of the request
+func setNextLink(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+	query := r.URL.Query()
+	query.Set("cursor", nextCursor)
+	next := *r.URL
+	next.RawQuery = query.Encode()
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
@@ -0,0 +1,130 @@
+// Package pubsub is an in-process hub fanning events out to
+// subscribers, such as the clients of the chirp stream
+package pubsub
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is something that happened on a topic. IDs increase by one for
+// every event published on the hub, across topics, starting from the
+// time the hub was created in microseconds. They keep increasing across
+// restarts, and stay below 2^53 so JavaScript clients read them
+// exactly.
+type Event struct {
+	ID    uint64
+	Topic string
+	Type  string
+	// Data is the JSON encoded payload
+	Data json.RawMessage
+}
+
+// Subscription receives the events accepted by its filter. C is closed
+// when the subscription is cancelled or when the subscriber fell so far
+// behind that its buffer filled up; it can then subscribe again from
+// the last event it handled.
+type Subscription struct {
+	C <-chan Event
+
+	c      chan Event
+	filter func(Event) bool
+	hub    *Hub
+}
+
+// Hub keeps the latest events so subscribers can resume after a
+// disconnection
+type Hub struct {
+	mux     sync.Mutex
+	lastID  uint64
+	history []Event
+	maxHist int
+	subs    map[*Subscription]bool
+	buffer  int
+}
+
+// NewHub creates a hub remembering the last history events and
+// buffering up to buffer events per subscriber
+func NewHub(history, buffer int) *Hub {
+	return &Hub{
+		lastID:  uint64(time.Now().UnixMicro()),
+		maxHist: history,
+		subs:    map[*Subscription]bool{},
+		buffer:  buffer,
+	}
+}
+
+// Publish sends an event to every matching subscriber without blocking
+func (h *Hub) Publish(topic, eventType string, data any) (Event, error) {
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.lastID++
+	event := Event{ID: h.lastID, Topic: topic, Type: eventType, Data: dat}
+	h.history = append(h.history, event)
+	if len(h.history) > h.maxHist {
+		h.history = h.history[len(h.history)-h.maxHist:]
+	}
+	for sub := range h.subs {
+		if !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+	return event, nil
+}
+
+// Subscribe registers a subscriber for the events filter accepts. The
+// remembered events after lastID are queued first, so resuming from
+// Last-Event-ID doesn't miss anything still in the history. An ID from
+// before a restart is lower than every event of this hub, so the whole
+// history is replayed.
+func (h *Hub) Subscribe(filter func(Event) bool, lastID uint64) *Subscription {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	var replay []Event
+	if lastID > 0 {
+		if lastID > h.lastID {
+			// Not an ID of this hub, e.g. the clock went back across a
+			// restart: there is no telling what was missed
+			lastID = 0
+		}
+		for _, event := range h.history {
+			if event.ID > lastID && filter(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+	c := make(chan Event, max(h.buffer, len(replay)))
+	for _, event := range replay {
+		c <- event
+	}
+	sub := &Subscription{C: c, c: c, filter: filter, hub: h}
+	h.subs[sub] = true
+	return sub
+}
+
+// Cancel stops the subscription and closes C. It is safe to call more
+// than once.
+func (s *Subscription) Cancel() {
+	s.hub.mux.Lock()
+	defer s.hub.mux.Unlock()
+	s.hub.remove(s)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,204 @@
+// Package search is an in-memory inverted index over chirp bodies,
+// ranking matches with BM25
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Tokenize splits text into lowercased runs of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Query is a parsed search: every term and every phrase must match
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+}
+
+// ParseQuery reads words and "quoted phrases". An unterminated quote
+// runs to the end of the query.
+func ParseQuery(q string) Query {
+	var query Query
+	for i, part := range strings.Split(q, `"`) {
+		tokens := Tokenize(part)
+		if i%2 == 0 || len(tokens) == 1 {
+			query.Terms = append(query.Terms, tokens...)
+		} else if len(tokens) > 1 {
+			query.Phrases = append(query.Phrases, tokens)
+		}
+	}
+	return query
+}
+
+// Empty reports whether the query has nothing to search for
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// words returns every token of the query, phrases included
+func (q Query) words() []string {
+	words := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+	return words
+}
+
+// Hit is a matching document and its score, higher is better
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// Index maps terms to the documents containing them. It is not safe for
+// concurrent use.
+type Index struct {
+	// postings holds the positions of each term in each document
+	postings map[string]map[int][]int
+	// terms holds the distinct terms of each document
+	terms map[int][]string
+	// lengths holds the number of tokens of each document
+	lengths  map[int]int
+	totalLen int
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: map[string]map[int][]int{},
+		terms:    map[int][]string{},
+		lengths:  map[int]int{},
+	}
+}
+
+// Add indexes text under id, replacing what was indexed for id before
+func (idx *Index) Add(id int, text string) {
+	idx.Remove(id)
+	tokens := Tokenize(text)
+	if len(tokens) == 0 {
+		return
+	}
+	for pos, token := range tokens {
+		if idx.postings[token] == nil {
+			idx.postings[token] = map[int][]int{}
+		}
+		if _, ok := idx.postings[token][id]; !ok {
+			idx.terms[id] = append(idx.terms[id], token)
+		}
+		idx.postings[token][id] = append(idx.postings[token][id], pos)
+	}
+	idx.lengths[id] = len(tokens)
+	idx.totalLen += len(tokens)
+}
+
+// Remove drops id from the index
+func (idx *Index) Remove(id int) {
+	n, ok := idx.lengths[id]
+	if !ok {
+		return
+	}
+	for _, term := range idx.terms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, id)
+	delete(idx.lengths, id)
+	idx.totalLen -= n
+}
+
+// Search returns the documents matching q that keep returns true for,
+// best first. Ties are broken by the newest (highest) ID.
+func (idx *Index) Search(q Query, keep func(id int) bool) []Hit {
+	words := q.words()
+	if len(words) == 0 {
+		return nil
+	}
+
+	// Start from the rarest word so the candidate set is small
+	rarest := words[0]
+	for _, word := range words[1:] {
+		if len(idx.postings[word]) < len(idx.postings[rarest]) {
+			rarest = word
+		}
+	}
+
+	var hits []Hit
+	for id := range idx.postings[rarest] {
+		if !idx.matches(id, q, words) || keep != nil && !keep(id) {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: idx.score(id, words)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	return hits
+}
+
+func (idx *Index) matches(id int, q Query, words []string) bool {
+	for _, word := range words {
+		if _, ok := idx.postings[word][id]; !ok {
+			return false
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if !idx.hasPhrase(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPhrase reports whether the words of phrase appear next to each
+// other, in order, in document id
+func (idx *Index) hasPhrase(id int, phrase []string) bool {
+	for _, start := range idx.postings[phrase[0]][id] {
+		found := true
+		for i, word := range phrase[1:] {
+			if !containsPos(idx.postings[word][id], start+i+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPos(positions []int, pos int) bool {
+	i := sort.SearchInts(positions, pos)
+	return i < len(positions) && positions[i] == pos
+}
+
+func (idx *Index) score(id int, words []string) float64 {
+	n := float64(len(idx.lengths))
+	avgLen := float64(idx.totalLen) / n
+	docLen := float64(idx.lengths[id])
+	score := 0.0
+	for _, word := range words {
+		df := float64(len(idx.postings[word]))
+		tf := float64(len(idx.postings[word][id]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*docLen/avgLen))
+	}
+	return score
+}
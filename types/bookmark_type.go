@@ -0,0 +1,11 @@
+package types
+
+import "time"
+
+// Bookmark is a chirp a user saved for later. Bookmarks are private to
+// the user who made them.
+type Bookmark struct {
+	UserID    int       `json:"user_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,7 +1,67 @@
 package types
 
+import "time"
+
 type Chirp struct {
-	Id   int    `json:"id"`
-	Body string `json:"body"`
-    AuthorID int `json:"author_id"`
+	Id        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Flagged marks chirps a moderation rule wants reviewed
+	Flagged bool `json:"flagged"`
+	// ParentID is the chirp this one replies to, 0 for top level chirps
+	ParentID int `json:"parent_id,omitempty"`
+	// Deleted marks a chirp hidden by its author or a moderator. It can
+	// be restored until it is purged: then a chirp that still has
+	// replies stays as a tombstone with an empty body, without DeletedAt.
+	Deleted bool `json:"deleted,omitempty"`
+	// DeletedAt is when the chirp was deleted, nil once it is purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// DeletedBy is the user who deleted the chirp
+	DeletedBy int `json:"deleted_by,omitempty"`
+	// Hashtags are the lowercased #tags of the body, without the '#'
+	Hashtags []string `json:"hashtags,omitempty"`
+	// Mentions are the IDs of the users @mentioned in the body
+	Mentions []int `json:"mentions,omitempty"`
+	// Attachments are the images uploaded for the chirp, in upload
+	// order
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Draft chirps are only visible to their author until published
+	Draft bool `json:"draft,omitempty"`
+	// PublishAt is when a scheduled chirp gets published, nil once it is
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	// BookmarkedAt is when the user of a ChirpQuery.BookmarkedBy listing
+	// bookmarked the chirp. It is not stored with the chirp.
+	BookmarkedAt *time.Time `json:"-"`
+}
+
+// Restorable reports whether the chirp is deleted but not purged yet
+func (c Chirp) Restorable() bool {
+	return c.Deleted && c.DeletedAt != nil
+}
+
+// Published reports whether the chirp is visible to everyone
+func (c Chirp) Published() bool {
+	return !c.Draft && c.PublishAt == nil
+}
+
+// Attachment is an image stored in the blob store under Key, with its
+// thumbnail under ThumbnailKey
+type Attachment struct {
+	Key          string `json:"key"`
+	ContentType  string `json:"content_type"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	ThumbnailKey string `json:"thumbnail_key"`
+}
+
+// ChirpVersion is a body a chirp had before it was edited
+type ChirpVersion struct {
+	ChirpID int    `json:"chirp_id"`
+	Version int    `json:"version"`
+	Body    string `json:"body"`
+	// CreatedAt is when this version was written
+	CreatedAt time.Time `json:"created_at"`
 }
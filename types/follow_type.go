@@ -0,0 +1,11 @@
+package types
+
+import "time"
+
+// Follow means FollowerID sees the chirps of FolloweeID in their
+// timeline
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+const (
+	// NotificationMention is sent to users mentioned in a chirp
+	NotificationMention = "mention"
+)
+
+// Notification tells UserID that ActorID did something involving
+// ChirpID
+type Notification struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorID   int       `json:"actor_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
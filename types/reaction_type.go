@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+const (
+	ReactionLike   = "like"
+	ReactionRepost = "repost"
+)
+
+// Reaction is a like or a repost of a chirp. A user reacts at most
+// once of each kind to a chirp.
+type Reaction struct {
+	Kind      string    `json:"kind"`
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
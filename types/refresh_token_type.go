@@ -2,8 +2,25 @@ package types
 
 import "time"
 
+// RefreshToken is a refresh token handed out at login. Only the
+// SHA-256 of the token is stored; the token itself is only known to the
+// client. The current token of a family is what users see as a session.
 type RefreshToken struct {
-	ExpireAt     time.Time `json:"expires_at"`
-	RefreshToken string `json:"refresh_token"`
-	UserID       int    `json:"user_id"`
+	// TokenHash is the hex encoded SHA-256 of the token
+	TokenHash string    `json:"token_hash"`
+	ExpireAt  time.Time `json:"expires_at"`
+	UserID    int       `json:"user_id"`
+	// FamilyID groups the tokens rotated from the same login. It is the
+	// TokenHash of the token issued at login.
+	FamilyID string `json:"family_id"`
+	// RotatedAt is when the token was exchanged for a new one, nil while
+	// it is the current token of its family
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	// CreatedAt is when the family started, at login
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt is when the token was issued, by a login or a refresh
+	LastUsedAt time.Time `json:"last_used_at"`
+	// UserAgent and IP are of the client the token was issued to
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
 }
@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+// Statuses of a report. An open report waits in the moderation queue
+// until a moderator resolves it with one of the other statuses.
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportHidden    = "hidden"
+	ReportSuspended = "suspended"
+)
+
+// Report is a user flagging a chirp as abusive
+type Report struct {
+	ID         int       `json:"id"`
+	ChirpID    int       `json:"chirp_id"`
+	ReporterID int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	// ResolvedAt is nil while the report is open
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
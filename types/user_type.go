@@ -1,10 +1,25 @@
 package types
 
+// Roles of a user. The role decides the scopes of the user's access
+// tokens.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	Id          int    `json:"id"`
 	Email       string `json:"email"`
 	Password    string `json:"password"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	// Suspended users can't log in or chirp
+	Suspended bool `json:"suspended"`
+	// TokenVersion is bumped to revoke every access token issued before
+	TokenVersion int `json:"token_version"`
+	// Role is one of the Role constants, empty for users created before
+	// roles, who are plain users
+	Role string `json:"role"`
 }
 
 type UpdateUser struct {